@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+//AccountsFile stores on the server a hash of the secret of every registered player name
+const AccountsFile = "accounts.json"
+
+//IdentitiesFile stores on the client the secret of every name it registered, by server address
+const IdentitiesFile = "identities.json"
+
+//NameRegisteredMessage structure gives a client the secret of the name it just registered,
+//the server only lets the name be used again with it
+type NameRegisteredMessage struct {
+	Name   string
+	Secret string
+}
+
+//MessageType function
+func (message *NameRegisteredMessage) MessageType() string {
+	return "name-registered"
+}
+
+//Accounts structure is the hash of the secret of every registered name, saved to File after every registration.
+//Ratings and tournaments trust names because only the holder of the secret can use a registered name
+type Accounts struct {
+	File   string
+	Mutex  sync.Mutex
+	Hashes map[string]string
+}
+
+//LoadAccounts function reads the accounts of file, a missing file has no accounts
+func LoadAccounts(file string) *Accounts {
+	accounts := &Accounts{File: file, Hashes: make(map[string]string)}
+	bytes, err := ioutil.ReadFile(file)
+	if err == nil {
+		json.Unmarshal(bytes, &accounts.Hashes)
+	}
+	return accounts
+}
+
+//HashSecret function returns the hexadecimal SHA-256 of a secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+//Registered function reports whether name has an account
+func (accounts *Accounts) Registered(name string) bool {
+	accounts.Mutex.Lock()
+	defer accounts.Mutex.Unlock()
+	_, ok := accounts.Hashes[name]
+	return ok
+}
+
+//Verify function checks the secret of name if it is registered, a free name needs no secret
+func (accounts *Accounts) Verify(name, secret string) error {
+	accounts.Mutex.Lock()
+	defer accounts.Mutex.Unlock()
+	if hash, ok := accounts.Hashes[name]; ok && subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) != 1 {
+		return errors.New("the name " + name + " belongs to another player, only the client that registered it can use it")
+	}
+	return nil
+}
+
+//Register function registers a free name and returns its new secret, the name must not be registered yet
+func (accounts *Accounts) Register(name string) (string, error) {
+	accounts.Mutex.Lock()
+	defer accounts.Mutex.Unlock()
+	if _, ok := accounts.Hashes[name]; ok {
+		return "", errors.New("the name " + name + " is already registered")
+	}
+	secret := NewSessionToken()
+	accounts.Hashes[name] = HashSecret(secret)
+	if err := ioutil.WriteFile(accounts.File, []byte(StructToJSONPretty(accounts.Hashes)), 0644); err != nil {
+		delete(accounts.Hashes, name)
+		return "", errors.New("the server could not register the name " + name)
+	}
+	return secret, nil
+}
+
+//IdentityKey function returns the key of the secret of name on a server in IdentitiesFile
+func IdentityKey(server, name string) string {
+	return name + "@" + NormalizeServerAddress(server)
+}
+
+//NormalizeServerAddress function returns the ip:port of a server address, so a server found by discovery as
+//tcp://ip:port, typed as ip:port or reached through its WebSocket keeps the same identities.
+//An address that does not resolve is returned without its scheme and path
+func NormalizeServerAddress(address string) string {
+	host := address
+	if strings.Contains(address, "://") {
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return address
+		}
+		host = parsed.Host
+		if parsed.Port() == "" {
+			port := "80"
+			if parsed.Scheme == "wss" {
+				port = "443"
+			}
+			host = net.JoinHostPort(parsed.Hostname(), port)
+		}
+	}
+	resolved, err := net.ResolveTCPAddr("tcp", host)
+	if err != nil {
+		return host
+	}
+	return resolved.String()
+}
+
+//LoadIdentities function reads IdentitiesFile, a missing file has no identities
+func LoadIdentities() map[string]string {
+	identities := make(map[string]string)
+	bytes, err := ioutil.ReadFile(IdentitiesFile)
+	if err == nil {
+		json.Unmarshal(bytes, &identities)
+	}
+	return identities
+}
+
+//SendName function sends the player name to the server with its secret, if this client registered it there.
+//With register the server registers the name for this client
+func (appManager *AppManager) SendName(register bool) {
+	secret := LoadIdentities()[IdentityKey(appManager.ServerAddress, appManager.PlayerName)]
+	appManager.SendMessage(&SetNameMessage{Name: appManager.PlayerName, Secret: secret, Register: register})
+}
+
+//SaveIdentity function stores the secret of a name the server registered for this client
+func (appManager *AppManager) SaveIdentity(message *NameRegisteredMessage) {
+	identities := LoadIdentities()
+	identities[IdentityKey(appManager.ServerAddress, message.Name)] = message.Secret
+	if err := ioutil.WriteFile(IdentitiesFile, []byte(StructToJSONPretty(identities)), 0600); err != nil {
+		appManager.WriteEntryAndUpdate("Could not save the secret of your name, you will not be able to use it again: " + err.Error())
+		return
+	}
+	appManager.WriteEntryAndUpdate("The name " + message.Name + " is now yours on this server, its secret is kept in " + IdentitiesFile)
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+//TestIdentityKey checks that every way to write the address of a server gives the same identity key
+func TestIdentityKey(t *testing.T) {
+	for _, test := range []struct {
+		address  string
+		expected string
+	}{
+		{"192.168.1.20:4000", "192.168.1.20:4000"},
+		{"tcp://192.168.1.20:4000", "192.168.1.20:4000"},
+		{"tls://192.168.1.20:4000", "192.168.1.20:4000"},
+		{"ws://192.168.1.20:4000" + WebSocketPath, "192.168.1.20:4000"},
+		{"wss://192.168.1.20" + WebSocketPath, "192.168.1.20:443"},
+		{"tcp://[::1]:4000", "[::1]:4000"},
+	} {
+		if key := IdentityKey(test.address, "Ana"); key != "Ana@"+test.expected {
+			t.Fatalf("the identity key of %s is %s instead of Ana@%s", test.address, key, test.expected)
+		}
+	}
+}
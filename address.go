@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"sort"
+)
+
+//Alcance de una direccion local, en el orden en que se ofrecen
+const (
+	PrivateScope Integer = iota
+	GlobalScope
+	AllInterfacesScope
+	LinkLocalScope
+	LoopbackScope
+)
+
+//ScopeNames labels every scope in the address list
+var ScopeNames = map[Integer]string{
+	PrivateScope:       "private",
+	GlobalScope:        "global",
+	AllInterfacesScope: "all interfaces",
+	LinkLocalScope:     "link-local",
+	LoopbackScope:      "loopback",
+}
+
+//LocalAddress structure is an address the host can listen on. Zone is the interface of link-local IPv6 addresses
+type LocalAddress struct {
+	IP        net.IP
+	Zone      string
+	Interface string
+	Scope     Integer
+}
+
+//AllInterfaces listens on every interface, IPv4 and IPv6
+var AllInterfaces = LocalAddress{IP: net.IPv6unspecified, Scope: AllInterfacesScope}
+
+//NewLocalAddress function labels an address of an interface with its scope
+func NewLocalAddress(ip net.IP, iface string) LocalAddress {
+	address := LocalAddress{IP: ip, Interface: iface}
+	switch {
+	case ip.IsLoopback():
+		address.Scope = LoopbackScope
+	case ip.IsLinkLocalUnicast():
+		address.Scope = LinkLocalScope
+		if ip.To4() == nil {
+			address.Zone = iface
+		}
+	case IsPrivateIP(ip):
+		address.Scope = PrivateScope
+	default:
+		address.Scope = GlobalScope
+	}
+	return address
+}
+
+//IsPrivateIP function reports whether ip belongs to a private IPv4 range or to the IPv6 unique local range
+func IsPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4[0] == 10 || (ip4[0] == 172 && ip4[1]&0xf0 == 16) || (ip4[0] == 192 && ip4[1] == 168)
+	}
+	return len(ip) == net.IPv6len && ip[0]&0xfe == 0xfc
+}
+
+//Family function returns IPv4 or IPv6
+func (address LocalAddress) Family() string {
+	if address.IP.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+//Host function returns the address as net.Listen expects it, with the zone of link-local IPv6 addresses
+func (address LocalAddress) Host() string {
+	if address.Zone != "" {
+		return address.IP.String() + "%" + address.Zone
+	}
+	return address.IP.String()
+}
+
+//String function describes the address for the address list
+func (address LocalAddress) String() string {
+	if address.Scope == AllInterfacesScope {
+		return "all interfaces (IPv4 and IPv6)"
+	}
+	return address.IP.String() + " (" + address.Interface + ", " + address.Family() + ", " + ScopeNames[address.Scope] + ")"
+}
+
+//SortLocalAddresses function sorts the addresses by scope, IPv4 before IPv6, then by interface name
+func SortLocalAddresses(addresses []LocalAddress) {
+	sort.SliceStable(addresses, func(i, j int) bool {
+		if addresses[i].Scope != addresses[j].Scope {
+			return addresses[i].Scope < addresses[j].Scope
+		}
+		if addresses[i].Family() != addresses[j].Family() {
+			return addresses[i].Family() == "IPv4"
+		}
+		return addresses[i].Interface < addresses[j].Interface
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Rutas HTTP del estado, las metricas y las acciones de administracion del lobby
+const (
+	StatusPath    = "/status"
+	MetricsPath   = "/metrics"
+	CloseRoomPath = "/admin/close-room"
+	KickPath      = "/admin/kick"
+)
+
+//RoomClosedMessage structure tells the clients of a room that an administrator closed it
+type RoomClosedMessage struct {
+	Reason string
+}
+
+//MessageType function
+func (message *RoomClosedMessage) MessageType() string {
+	return "room-closed"
+}
+
+//Metrics structure counts the traffic and the matches of the lobby, its counters are updated atomically.
+//Frames sent include heartbeats, messages received do not
+type Metrics struct {
+	Connections      int64
+	MessagesReceived int64
+	FramesSent       int64
+	BytesReceived    int64
+	BytesSent        int64
+	MatchesStarted   int64
+	mutex            sync.Mutex
+	matchStarts      []time.Time
+}
+
+//MatchStarted function counts a match and remembers when it started for MatchesPerMinute
+func (metrics *Metrics) MatchStarted(now time.Time) {
+	atomic.AddInt64(&metrics.MatchesStarted, 1)
+	metrics.mutex.Lock()
+	metrics.matchStarts = append(metrics.matchStarts, now)
+	metrics.mutex.Unlock()
+}
+
+//MatchesPerMinute function returns the number of matches started during the last minute
+func (metrics *Metrics) MatchesPerMinute(now time.Time) Integer {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	recent := metrics.matchStarts[:0]
+	for _, start := range metrics.matchStarts {
+		if now.Sub(start) < time.Minute {
+			recent = append(recent, start)
+		}
+	}
+	metrics.matchStarts = recent
+	return Integer(len(recent))
+}
+
+//CountingConn structure counts the bytes and the frames that go through a connection of the lobby.
+//Every frame is written with a single Write
+type CountingConn struct {
+	net.Conn
+	metrics *Metrics
+}
+
+//Read function
+func (conn *CountingConn) Read(bytes []byte) (int, error) {
+	read, err := conn.Conn.Read(bytes)
+	atomic.AddInt64(&conn.metrics.BytesReceived, int64(read))
+	return read, err
+}
+
+//Write function
+func (conn *CountingConn) Write(bytes []byte) (int, error) {
+	written, err := conn.Conn.Write(bytes)
+	atomic.AddInt64(&conn.metrics.BytesSent, int64(written))
+	atomic.AddInt64(&conn.metrics.FramesSent, 1)
+	return written, err
+}
+
+//RoomStatus structure describes a room in the status
+type RoomStatus struct {
+	Name       string
+	Players    []string
+	Playing    bool
+	Locked     bool
+	Ranked     bool
+	Tournament string
+}
+
+//PlayerStatus structure describes a connected client in the status, Address is only shown to administrators
+type PlayerStatus struct {
+	Name    string
+	Address string
+	Room    string
+	Queued  bool
+}
+
+//ServerStatus structure is the answer of StatusPath
+type ServerStatus struct {
+	BuildVersion  string
+	Started       string
+	UptimeSeconds int64
+	Rooms         []RoomStatus
+	Players       []PlayerStatus
+	Matches       Integer
+	Tournaments   []string
+}
+
+//Status function describes the rooms, the connected players and the running matches of the lobby,
+//with the addresses of the players if addresses is true
+func (lobby *Lobby) Status(addresses bool) ServerStatus {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	status := ServerStatus{
+		BuildVersion:  BuildVersion,
+		Started:       lobby.Started.UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(time.Since(lobby.Started) / time.Second),
+		Rooms:         []RoomStatus{},
+		Players:       []PlayerStatus{},
+		Tournaments:   []string{},
+	}
+	for _, room := range lobby.Rooms {
+		roomStatus := RoomStatus{Name: room.Name, Players: append([]string(nil), room.Names...), Playing: room.Playing, Locked: room.Locked, Ranked: room.Ranked}
+		if room.Tournament != nil {
+			roomStatus.Tournament = room.Tournament.Name
+		}
+		if room.Playing {
+			status.Matches++
+		}
+		status.Rooms = append(status.Rooms, roomStatus)
+	}
+	for client := range lobby.Clients {
+		player := PlayerStatus{Name: client.Name, Queued: client.Queued}
+		if addresses {
+			player.Address = client.Connection.Conn.RemoteAddr().String()
+		}
+		if client.Room != nil {
+			player.Room = client.Room.Name
+		}
+		status.Players = append(status.Players, player)
+	}
+	for name := range lobby.Tournaments {
+		status.Tournaments = append(status.Tournaments, name)
+	}
+	sort.Slice(status.Rooms, func(i, j int) bool { return status.Rooms[i].Name < status.Rooms[j].Name })
+	sort.Slice(status.Players, func(i, j int) bool { return status.Players[i].Name < status.Players[j].Name })
+	sort.Strings(status.Tournaments)
+	return status
+}
+
+//ServeAdmin function adds the status, the metrics and, if token is not empty, the admin actions to the HTTP endpoints of the lobby.
+//The status shows the addresses of the players only to requests with the token
+func (lobby *Lobby) ServeAdmin(token string) {
+	lobby.Mux.HandleFunc(StatusPath, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(writer, StructToJSON(lobby.Status(Authorized(request, token))))
+	})
+	lobby.Mux.HandleFunc(MetricsPath, lobby.ServeMetrics)
+	lobby.Mux.HandleFunc(CloseRoomPath, lobby.adminAction(token, func(request *http.Request) error {
+		return lobby.CloseRoom(request.FormValue("name"), "closed by an administrator")
+	}))
+	lobby.Mux.HandleFunc(KickPath, lobby.adminAction(token, func(request *http.Request) error {
+		if lobby.KickPlayer(request.FormValue("player"), "kicked by an administrator") == 0 {
+			return fmt.Errorf("there is no player %q", request.FormValue("player"))
+		}
+		return nil
+	}))
+}
+
+//adminAction function wraps an admin action: it must be a POST with the header "Authorization: Bearer <token>"
+func (lobby *Lobby) adminAction(token string, action func(*http.Request) error) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if token == "" {
+			http.Error(writer, "admin actions are disabled, start the server with -admin-token", http.StatusForbidden)
+			return
+		}
+		if request.Method != http.MethodPost {
+			writer.Header().Set("Allow", http.MethodPost)
+			http.Error(writer, "admin actions must be POST requests", http.StatusMethodNotAllowed)
+			return
+		}
+		if !Authorized(request, token) {
+			lobby.Log("Rejected admin request " + request.URL.Path + " from " + request.RemoteAddr)
+			http.Error(writer, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		if err := action(request); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		lobby.Log("Admin request " + request.URL.Path + "?" + request.Form.Encode() + " from " + request.RemoteAddr)
+		fmt.Fprintln(writer, "ok")
+	}
+}
+
+//Authorized function reports whether request has the header "Authorization: Bearer <token>", an empty token authorizes nobody
+func Authorized(request *http.Request, token string) bool {
+	given := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+//ServeMetrics function writes the metrics of the lobby in the Prometheus text format
+func (lobby *Lobby) ServeMetrics(writer http.ResponseWriter, request *http.Request) {
+	status := lobby.Status(false)
+	metrics := lobby.Metrics
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		Name  string
+		Type  string
+		Help  string
+		Value int64
+	}{
+		{"gamethegame_connections", "gauge", "Clients connected to the lobby.", int64(len(status.Players))},
+		{"gamethegame_connections_total", "counter", "Clients that completed the hello since the server started.", atomic.LoadInt64(&metrics.Connections)},
+		{"gamethegame_rooms", "gauge", "Open rooms.", int64(len(status.Rooms))},
+		{"gamethegame_matches", "gauge", "Matches being played.", int64(status.Matches)},
+		{"gamethegame_matches_started_total", "counter", "Matches started since the server started.", atomic.LoadInt64(&metrics.MatchesStarted)},
+		{"gamethegame_matches_per_minute", "gauge", "Matches started during the last minute.", int64(metrics.MatchesPerMinute(time.Now()))},
+		{"gamethegame_messages_received_total", "counter", "Messages received from clients, heartbeats excluded.", atomic.LoadInt64(&metrics.MessagesReceived)},
+		{"gamethegame_frames_sent_total", "counter", "Frames sent to clients, heartbeats included.", atomic.LoadInt64(&metrics.FramesSent)},
+		{"gamethegame_received_bytes_total", "counter", "Bytes received from clients.", atomic.LoadInt64(&metrics.BytesReceived)},
+		{"gamethegame_sent_bytes_total", "counter", "Bytes sent to clients.", atomic.LoadInt64(&metrics.BytesSent)},
+		{"gamethegame_uptime_seconds", "gauge", "Seconds since the server started.", status.UptimeSeconds},
+	} {
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.Name, metric.Help, metric.Name, metric.Type, metric.Name, metric.Value)
+	}
+}
+
+//CloseRoom function closes a room: a waiting room at once, a running match ends without a result for the ratings
+//and as a draw for its tournament
+func (lobby *Lobby) CloseRoom(name, reason string) error {
+	lobby.Mutex.Lock()
+	room := lobby.Rooms[name]
+	if room == nil {
+		lobby.Mutex.Unlock()
+		return fmt.Errorf("there is no room %q", name)
+	}
+	if !room.Playing {
+		room.Broadcast(&RoomClosedMessage{Reason: reason})
+		lobby.closeRoom(room)
+		lobby.Mutex.Unlock()
+		return nil
+	}
+	lobby.Mutex.Unlock()
+	room.Send(RoomMessage{Message: &RoomClosedMessage{Reason: reason}})
+	return nil
+}
+
+//KickPlayer function expels the clients whose name or address is player and returns how many there were
+func (lobby *Lobby) KickPlayer(player, reason string) Integer {
+	lobby.Mutex.Lock()
+	var clients []*Client
+	for client := range lobby.Clients {
+		if client.Name == player || client.Connection.Conn.RemoteAddr().String() == player {
+			clients = append(clients, client)
+		}
+	}
+	lobby.Mutex.Unlock()
+	for _, client := range clients {
+		lobby.Expel(client, reason)
+	}
+	return Integer(len(clients))
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//CampaignFile holds the levels of the campaign, in the order they unlock
+const CampaignFile = "campaign.json"
+
+//CampaignProgressFile stores the progress of every profile in the campaign
+const CampaignProgressFile = "campaign_progress.json"
+
+//CampaignRules structure overrides the rules of the starting hand in a level: its Credit and its number of cards,
+//zero keeps StartingCredit and StartingHandSize. Banned cards can not be chosen in the level
+type CampaignRules struct {
+	Credit   Integer
+	HandSize Integer
+	Banned   []string
+}
+
+//CampaignReward structure is what completing a level for the first time gives: Credit for the next levels and new Cards
+type CampaignReward struct {
+	Credit Integer
+	Cards  []string
+}
+
+//CampaignLevel structure is a level of the campaign. Enemy is the board of the enemy wave, row by row,
+//with the names of its cards and an empty name for an empty cell
+type CampaignLevel struct {
+	Name        string
+	Description string
+	Enemy       [][]string
+	Rules       CampaignRules
+	Reward      CampaignReward
+}
+
+//Campaign structure is the sequence of levels of the campaign and the cards every profile starts with
+type Campaign struct {
+	StartingCards []string
+	Levels        []*CampaignLevel
+}
+
+//LoadCampaign function reads and validates the campaign of file
+func LoadCampaign(file string) (*Campaign, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var campaign Campaign
+	if err := json.Unmarshal(bytes, &campaign); err != nil {
+		return nil, err
+	}
+	return &campaign, campaign.Validate()
+}
+
+//Validate function checks that every card of the campaign exists and that every enemy board fits in the board
+func (campaign *Campaign) Validate() error {
+	if len(campaign.Levels) == 0 {
+		return errors.New("la campaña no tiene niveles")
+	}
+	if err := validateCardNames(campaign.StartingCards); err != nil {
+		return err
+	}
+	for number, level := range campaign.Levels {
+		where := "el nivel " + strconv.Itoa(number+1)
+		if Integer(len(level.Enemy)) > BoardRows {
+			return errors.New(where + " tiene mas de " + strconv.Itoa(int(BoardRows)) + " filas")
+		}
+		for _, row := range level.Enemy {
+			if Integer(len(row)) > BoardColumns {
+				return errors.New(where + " tiene mas de " + strconv.Itoa(int(BoardColumns)) + " columnas")
+			}
+			var names []string
+			for _, name := range row {
+				if name != "" {
+					names = append(names, name)
+				}
+			}
+			if err := validateCardNames(names); err != nil {
+				return errors.New(where + ": " + err.Error())
+			}
+		}
+		if level.Rules.Credit < 0 || level.Rules.HandSize < 0 || level.Reward.Credit < 0 {
+			return errors.New(where + " tiene reglas o recompensas negativas")
+		}
+		if err := validateCardNames(level.Rules.Banned); err != nil {
+			return errors.New(where + ": " + err.Error())
+		}
+		if err := validateCardNames(level.Reward.Cards); err != nil {
+			return errors.New(where + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+//validateCardNames function checks that every name is the name of a card
+func validateCardNames(names []string) error {
+	for _, name := range names {
+		if FindCardIndexByName(name) < 0 {
+			return errors.New("la carta " + name + " no existe")
+		}
+	}
+	return nil
+}
+
+//Credit function returns the credit of the level for a profile that earned bonus credit in earlier levels
+func (level *CampaignLevel) Credit(bonus Integer) Integer {
+	if level.Rules.Credit == 0 {
+		return StartingCredit + bonus
+	}
+	return level.Rules.Credit + bonus
+}
+
+//HandSize function returns the number of cards of the hand of the level
+func (level *CampaignLevel) HandSize() Integer {
+	if level.Rules.HandSize == 0 {
+		return StartingHandSize
+	}
+	return level.Rules.HandSize
+}
+
+//EnemyPlayer function returns a player with the enemy board of the level
+func (level *CampaignLevel) EnemyPlayer() *Player {
+	enemy := &Player{Board: make([][]Card, BoardRows)}
+	for row := range enemy.Board {
+		enemy.Board[row] = make([]Card, BoardColumns)
+		if row >= len(level.Enemy) {
+			continue
+		}
+		for column, name := range level.Enemy[row] {
+			if name != "" {
+				enemy.Board[row][column] = *ArregloDeCartas[FindCardIndexByName(name)]
+			}
+		}
+	}
+	return enemy
+}
+
+//CardPower function adds up the damage, armor, healing and health of a card. Until combat exists
+//a level is won by a hand with at least the power of the enemy board
+func CardPower(card *Card) Integer {
+	return card.RedDamage + card.BlueDamage + card.RedArmor + card.BlueArmor + card.Healing + card.Health
+}
+
+//EnemyPower function adds up the power of the cards of the enemy board of the level
+func (level *CampaignLevel) EnemyPower() Integer {
+	var power Integer
+	for _, row := range level.EnemyPlayer().Board {
+		for index := range row {
+			if row[index].Name != "" {
+				power += CardPower(&row[index])
+			}
+		}
+	}
+	return power
+}
+
+//HandPower function adds up the power of the chosen cards
+func HandPower(indices []Integer) Integer {
+	var power Integer
+	for _, index := range indices {
+		power += CardPower(ArregloDeCartas[index])
+	}
+	return power
+}
+
+//CampaignProgress structure is the progress of a profile: how many levels it Completed, which unlock in order,
+//the bonus Credit and the Cards it earned
+type CampaignProgress struct {
+	Completed Integer
+	Credit    Integer
+	Cards     []string
+}
+
+//LoadCampaignProgress function reads the progress of every profile in file, a missing file has no profiles
+func LoadCampaignProgress(file string) map[string]*CampaignProgress {
+	progress := make(map[string]*CampaignProgress)
+	bytes, err := ioutil.ReadFile(file)
+	if err == nil {
+		json.Unmarshal(bytes, &progress)
+	}
+	return progress
+}
+
+//SaveCampaignProgress function writes the progress of every profile to file
+func SaveCampaignProgress(file string, progress map[string]*CampaignProgress) error {
+	return ioutil.WriteFile(file, []byte(StructToJSONPretty(progress)), 0644)
+}
+
+//NewCampaignProgress function returns the progress of a new profile, with the starting cards of the campaign
+func (campaign *Campaign) NewCampaignProgress() *CampaignProgress {
+	return &CampaignProgress{Cards: append([]string(nil), campaign.StartingCards...)}
+}
+
+//Unlocked function reports whether the level with index number is unlocked, levels unlock in order
+func (progress *CampaignProgress) Unlocked(number Integer) bool {
+	return number <= progress.Completed
+}
+
+//AllowedCards function returns the indices in ArregloDeCartas of the cards the profile can choose in level
+func (progress *CampaignProgress) AllowedCards(level *CampaignLevel) map[Integer]bool {
+	allowed := make(map[Integer]bool)
+	for _, name := range progress.Cards {
+		allowed[FindCardIndexByName(name)] = true
+	}
+	for _, name := range level.Rules.Banned {
+		delete(allowed, FindCardIndexByName(name))
+	}
+	return allowed
+}
+
+//Complete function records that the profile won the level with index number. Only the first win of the last unlocked level
+//counts: it unlocks the next level and gives the reward, Complete returns whether it did
+func (progress *CampaignProgress) Complete(campaign *Campaign, number Integer) bool {
+	if number != progress.Completed || number >= Integer(len(campaign.Levels)) {
+		return false
+	}
+	reward := campaign.Levels[number].Reward
+	progress.Completed++
+	progress.Credit += reward.Credit
+	for _, name := range reward.Cards {
+		known := false
+		for _, card := range progress.Cards {
+			known = known || strings.EqualFold(card, name)
+		}
+		if !known {
+			progress.Cards = append(progress.Cards, name)
+		}
+	}
+	return true
+}
+
+//RewardString function describes a reward
+func (reward CampaignReward) RewardString() string {
+	var parts []string
+	if reward.Credit > 0 {
+		parts = append(parts, fmt.Sprintf("%d de credito", reward.Credit))
+	}
+	if len(reward.Cards) > 0 {
+		parts = append(parts, "las cartas "+strings.Join(reward.Cards, ", "))
+	}
+	if len(parts) == 0 {
+		return "nada"
+	}
+	return strings.Join(parts, " y ")
+}
+
+//CampaignString function lists the levels of the campaign with the state of each one for a profile
+func (campaign *Campaign) CampaignString(progress *CampaignProgress) string {
+	lines := []string{"Niveles de la campaña:"}
+	for number, level := range campaign.Levels {
+		state := "bloqueado"
+		if Integer(number) < progress.Completed {
+			state = "completado"
+		} else if progress.Unlocked(Integer(number)) {
+			state = "disponible"
+		}
+		lines = append(lines, strconv.Itoa(number+1)+") "+level.Name+" ("+state+")")
+	}
+	return strings.Join(lines, "\n")
+}
+
+//PlayCampaign function plays the levels of the campaign with the profile of the player and saves its progress after every win
+func (appManager *AppManager) PlayCampaign() {
+	campaign, err := LoadCampaign(CampaignFile)
+	if err != nil {
+		appManager.WriteEntryAndUpdate("No se pudo leer la campaña de " + CampaignFile + ": " + err.Error())
+		return
+	}
+	appManager.WriteEntryAndUpdate("Escribe el nombre de tu perfil:")
+	profile := ""
+	for profile == "" {
+		profile = CleanChatText(string(appManager.ReadCommand()))
+	}
+	profiles := LoadCampaignProgress(CampaignProgressFile)
+	progress := profiles[profile]
+	if progress == nil {
+		progress = campaign.NewCampaignProgress()
+		profiles[profile] = progress
+	}
+	for {
+		if progress.Completed >= Integer(len(campaign.Levels)) {
+			appManager.WriteEntry("Completaste la campaña, puedes volver a jugar cualquier nivel")
+		}
+		appManager.WriteEntryAndUpdate(campaign.CampaignString(progress) + "\nEscribe el numero de un nivel o \"salir\"")
+		command := strings.TrimSpace(string(appManager.ReadCommand()))
+		if strings.EqualFold(command, "salir") {
+			return
+		}
+		number, err := strconv.Atoi(command)
+		if err != nil || number < 1 || number > len(campaign.Levels) {
+			appManager.WriteEntryAndUpdate("Escribe un numero entre 1 y " + strconv.Itoa(len(campaign.Levels)))
+			continue
+		}
+		if !progress.Unlocked(Integer(number - 1)) {
+			appManager.WriteEntryAndUpdate("Ese nivel esta bloqueado, completa antes los niveles anteriores")
+			continue
+		}
+		if appManager.PlayCampaignLevel(campaign, progress, Integer(number-1)) {
+			if err := SaveCampaignProgress(CampaignProgressFile, profiles); err != nil {
+				appManager.WriteEntryAndUpdate("No se pudo guardar tu progreso: " + err.Error())
+			}
+		}
+	}
+}
+
+//PlayCampaignLevel function plays the level with index number and returns whether the progress changed
+func (appManager *AppManager) PlayCampaignLevel(campaign *Campaign, progress *CampaignProgress, number Integer) bool {
+	level := campaign.Levels[number]
+	credit, size := level.Credit(progress.Credit), level.HandSize()
+	appManager.WriteEntry(level.Name + "\n" + level.Description)
+	appManager.WriteEntry("Tablero enemigo, poder " + strconv.Itoa(int(level.EnemyPower())) + ":\n" + BoardString(level.EnemyPlayer()))
+	rules := "Mano de " + strconv.Itoa(int(size)) + " cartas con " + strconv.Itoa(int(credit)) + " de credito"
+	if len(level.Rules.Banned) > 0 {
+		rules += ", prohibidas: " + strings.Join(level.Rules.Banned, ", ")
+	}
+	appManager.WriteEntry(rules + "\nRecompensa: " + level.Reward.RewardString())
+	prompt := appManager.NewRestrictedHandPrompt(size, credit, progress.AllowedCards(level))
+	var indices []Integer
+	for done := false; !done; {
+		indices, done = prompt.Feed(appManager, string(appManager.ReadCommand()))
+	}
+	power, enemy := HandPower(indices), level.EnemyPower()
+	if power < enemy {
+		appManager.WriteEntryAndUpdate("Tu mano tiene poder " + strconv.Itoa(int(power)) + " y el enemigo " + strconv.Itoa(int(enemy)) + ", perdiste el nivel")
+		return false
+	}
+	appManager.WriteEntry("Tu mano tiene poder " + strconv.Itoa(int(power)) + " y el enemigo " + strconv.Itoa(int(enemy)) + ", ganaste el nivel")
+	if !progress.Complete(campaign, number) {
+		appManager.WriteEntryAndUpdate("Ya habias completado este nivel")
+		return false
+	}
+	appManager.WriteEntryAndUpdate("Obtuviste " + level.Reward.RewardString())
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//bestCampaignHand function returns the most powerful hand a profile can choose in a level
+func bestCampaignHand(progress *CampaignProgress, level *CampaignLevel) []Integer {
+	var indices []Integer
+	for index := range progress.AllowedCards(level) {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return CardPower(ArregloDeCartas[indices[i]]) > CardPower(ArregloDeCartas[indices[j]])
+	})
+	if Integer(len(indices)) > level.HandSize() {
+		indices = indices[:level.HandSize()]
+	}
+	return indices
+}
+
+//TestCampaignFile checks that every level of CampaignFile unlocks in order and can be won with the cards and the credit
+//that the earlier levels give
+func TestCampaignFile(t *testing.T) {
+	campaign, err := LoadCampaign(CampaignFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := campaign.NewCampaignProgress()
+	for number, level := range campaign.Levels {
+		if !progress.Unlocked(Integer(number)) || progress.Unlocked(Integer(number+1)) {
+			t.Fatalf("level %d is not the last unlocked level", number+1)
+		}
+		hand := bestCampaignHand(progress, level)
+		if err := ValidateHand(hand, level.HandSize(), level.Credit(progress.Credit)); err != nil {
+			t.Fatalf("level %d can not be played: %v", number+1, err)
+		}
+		if HandPower(hand) < level.EnemyPower() {
+			t.Fatalf("level %d can not be won, the best hand has power %d and the enemy %d", number+1, HandPower(hand), level.EnemyPower())
+		}
+		if !progress.Complete(campaign, Integer(number)) || progress.Complete(campaign, Integer(number)) {
+			t.Fatalf("level %d was not completed exactly once", number+1)
+		}
+	}
+}
+
+//TestCampaignProgress checks that rewards are given once, that progress survives being saved
+//and that a campaign with unknown cards or a board too large is rejected
+func TestCampaignProgress(t *testing.T) {
+	campaign := &Campaign{
+		StartingCards: []string{"Ninja"},
+		Levels: []*CampaignLevel{
+			{Name: "uno", Enemy: [][]string{{"Ninja"}}, Reward: CampaignReward{Credit: 1, Cards: []string{"Ogro", "Ninja"}}},
+			{Name: "dos", Enemy: [][]string{{"", "Ogro"}}, Rules: CampaignRules{Credit: 2, HandSize: 1, Banned: []string{"Ninja"}}},
+		},
+	}
+	if err := campaign.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	progress := campaign.NewCampaignProgress()
+	if progress.Complete(campaign, 1) {
+		t.Fatal("a locked level was completed")
+	}
+	if !progress.Complete(campaign, 0) || progress.Complete(campaign, 0) {
+		t.Fatal("the first level was not completed exactly once")
+	}
+	if progress.Credit != 1 || !reflect.DeepEqual(progress.Cards, []string{"Ninja", "Ogro"}) {
+		t.Fatalf("the reward left %d of credit and the cards %v", progress.Credit, progress.Cards)
+	}
+	level := campaign.Levels[1]
+	if level.Credit(progress.Credit) != 3 || level.HandSize() != 1 {
+		t.Fatalf("the second level gives %d of credit for %d cards", level.Credit(progress.Credit), level.HandSize())
+	}
+	if allowed := progress.AllowedCards(level); len(allowed) != 1 || !allowed[FindCardIndexByName("Ogro")] {
+		t.Fatalf("the second level allows %v", allowed)
+	}
+	file := filepath.Join(t.TempDir(), CampaignProgressFile)
+	if err := SaveCampaignProgress(file, map[string]*CampaignProgress{"Ana": progress}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := LoadCampaignProgress(file)["Ana"]; !reflect.DeepEqual(loaded, progress) {
+		t.Fatalf("the progress was loaded as %+v", loaded)
+	}
+	campaign.Levels[0].Enemy[0][0] = "Dragon"
+	if campaign.Validate() == nil {
+		t.Fatal("a campaign with an unknown card was accepted")
+	}
+	campaign.Levels[0].Enemy = [][]string{{}, {}, {}}
+	if campaign.Validate() == nil {
+		t.Fatal("a campaign with an enemy board too large was accepted")
+	}
+}
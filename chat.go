@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//Limites del chat: largo de un mensaje y de un nombre, rafaga de mensajes permitida y cada cuanto se recupera uno
+const (
+	MaximumChatLength = 200
+	MaximumNameLength = 24
+	ChatBurst         = 5
+	ChatRefill        = 2 * time.Second
+)
+
+//SetNameMessage structure sets the name other players see in the chat and that ratings and tournaments use.
+//Secret is the one the server gave when it registered the name, empty for a name this client never registered on the server.
+//Register asks the server to register a free name for this client
+type SetNameMessage struct {
+	Name     string
+	Secret   string
+	Register bool
+}
+
+//MessageType function
+func (message *SetNameMessage) MessageType() string {
+	return "set-name"
+}
+
+//ChatMessage structure is sent by a client with only Text, the server fills From and Time and relays it to the room
+type ChatMessage struct {
+	From string
+	Text string
+	Time int64
+}
+
+//MessageType function
+func (message *ChatMessage) MessageType() string {
+	return "chat"
+}
+
+//RateLimiter structure is a token bucket that allows Burst actions at once and recovers one every Refill
+type RateLimiter struct {
+	Burst  float64
+	Refill time.Duration
+	tokens float64
+	last   time.Time
+}
+
+//NewRateLimiter function creates a full token bucket
+func NewRateLimiter(burst Integer, refill time.Duration) *RateLimiter {
+	return &RateLimiter{Burst: float64(burst), Refill: refill, tokens: float64(burst), last: time.Now()}
+}
+
+//Allow function takes a token if there is one
+func (limiter *RateLimiter) Allow() bool {
+	now := time.Now()
+	limiter.tokens += float64(now.Sub(limiter.last)) / float64(limiter.Refill)
+	if limiter.tokens > limiter.Burst {
+		limiter.tokens = limiter.Burst
+	}
+	limiter.last = now
+	if limiter.tokens < 1 {
+		return false
+	}
+	limiter.tokens--
+	return true
+}
+
+//CleanChatText function removes control characters and surrounding spaces
+func CleanChatText(text string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text))
+}
+
+//ValidateName function checks a player name
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("the name can not be empty")
+	}
+	if utf8.RuneCountInString(name) > MaximumNameLength {
+		return errors.New("the name can not be longer than " + strconv.Itoa(MaximumNameLength) + " characters")
+	}
+	return nil
+}
+
+//GuestPrefix starts the names the lobby gives to clients until they choose one
+const GuestPrefix = "Guest "
+
+//SetName function changes the name of a client after validating it. A registered name needs its secret,
+//no name can be in use by two clients and a free name is only registered for a client that asks with register.
+//The account is saved without holding the lock of the lobby
+func (lobby *Lobby) SetName(client *Client, name, secret string, register bool) error {
+	name = CleanChatText(name)
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if strings.HasPrefix(name, GuestPrefix) {
+		return errors.New("names starting with " + strings.TrimSpace(GuestPrefix) + " are reserved for players without a name")
+	}
+	if err := lobby.checkName(client, name); err != nil {
+		return err
+	}
+	if err := lobby.Accounts.Verify(name, secret); err != nil {
+		return err
+	}
+	issued := ""
+	if register {
+		if lobby.Accounts.Registered(name) {
+			return errors.New("the name " + name + " is already yours")
+		}
+		var err error
+		if issued, err = lobby.Accounts.Register(name); err != nil {
+			return err
+		}
+	}
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if err := lobby.checkNameLocked(client, name); err != nil {
+		return err
+	}
+	client.Name = name
+	client.Named = true
+	if issued != "" {
+		client.Connection.Send(&NameRegisteredMessage{Name: name, Secret: issued})
+	}
+	return nil
+}
+
+//checkName function checks that client can take name now
+func (lobby *Lobby) checkName(client *Client, name string) error {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	return lobby.checkNameLocked(client, name)
+}
+
+//checkNameLocked function checks that client is not queued and that no other client uses name,
+//the caller holds the lock of the lobby
+func (lobby *Lobby) checkNameLocked(client *Client, name string) error {
+	if client.Queued {
+		return errors.New("leave the matchmaking queue to change your name")
+	}
+	for other := range lobby.Clients {
+		if other != client && other.Name == name {
+			return errors.New("the name " + name + " is being used by another player")
+		}
+	}
+	return nil
+}
+
+//HandleChat function validates a chat message of a client and relays it to everyone in the client's room
+func (lobby *Lobby) HandleChat(client *Client, message *ChatMessage) error {
+	if !client.Connection.HasFeature(ChatFeature) {
+		return errors.New("your build does not support chat")
+	}
+	text := CleanChatText(message.Text)
+	if text == "" {
+		return errors.New("the message is empty")
+	}
+	if utf8.RuneCountInString(text) > MaximumChatLength {
+		return errors.New("messages can not be longer than " + strconv.Itoa(MaximumChatLength) + " characters")
+	}
+	lobby.Mutex.Lock()
+	room := client.Room
+	name := client.Name
+	var clients []*Client
+	if room != nil {
+		clients = append(clients, room.Clients...)
+	}
+	lobby.Mutex.Unlock()
+	if room == nil {
+		return errors.New("join a room to chat")
+	}
+	if !client.ChatLimiter.Allow() {
+		return errors.New("you are sending messages too fast")
+	}
+	relayed := &ChatMessage{From: name, Text: text, Time: time.Now().Unix()}
+	for _, other := range clients {
+		if other != nil {
+			other.Connection.Send(relayed)
+		}
+	}
+	return nil
+}
+
+//HandleChatCommand function runs /say, /mute, /name and /register, it returns false if command is not one of them
+func (appManager *AppManager) HandleChatCommand(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+	argument := strings.TrimSpace(strings.TrimPrefix(command, fields[0]))
+	switch strings.ToLower(fields[0]) {
+	case "/say":
+		if !appManager.Connection.HasFeature(ChatFeature) {
+			appManager.WriteEntryAndUpdate("The other side does not support chat")
+			return true
+		}
+		message := &ChatMessage{Text: argument}
+		if appManager.ValidateRequest(message) {
+			appManager.SendMessage(message)
+		}
+	case "/name":
+		name := CleanChatText(argument)
+		if err := ValidateName(name); err != nil {
+			appManager.WriteEntryAndUpdate(err.Error())
+			return true
+		}
+		appManager.PlayerName = name
+		appManager.SendName(false)
+	case "/register":
+		if appManager.PlayerName == "" {
+			appManager.WriteEntryAndUpdate("Choose a name with /name before registering it")
+			return true
+		}
+		appManager.SendName(true)
+	case "/mute":
+		if appManager.Muted == nil {
+			appManager.Muted = make(map[string]bool)
+		}
+		appManager.Muted[argument] = !appManager.Muted[argument]
+		who := "the chat"
+		if argument != "" {
+			who = argument
+		}
+		if appManager.Muted[argument] {
+			appManager.WriteEntryAndUpdate("Muted " + who + ", type the same command again to unmute")
+		} else {
+			appManager.WriteEntryAndUpdate("Unmuted " + who)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+//AskPlayerName function asks the name the other players see in the chat and sends it to the server
+func (appManager *AppManager) AskPlayerName() {
+	appManager.WriteEntryAndUpdate("Enter your player name:")
+	for {
+		name := CleanChatText(string(appManager.ReadCommand()))
+		if err := ValidateName(name); err != nil {
+			appManager.WriteEntryAndUpdate(err.Error())
+			continue
+		}
+		appManager.PlayerName = name
+		appManager.SendName(false)
+		return
+	}
+}
+
+//ShowChat function writes a chat message in the BufferWidget unless its sender or the whole chat is muted
+func (appManager *AppManager) ShowChat(message *ChatMessage) {
+	if message.From != appManager.PlayerName && (appManager.Muted[""] || appManager.Muted[message.From]) {
+		return
+	}
+	timestamp := time.Unix(message.Time, 0).Format("15:04")
+	appManager.WriteEntryAndUpdate("[" + timestamp + "] " + message.From + ": " + message.Text)
+}
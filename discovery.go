@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gdamore/tcell"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Puerto UDP de los anuncios, cada cuanto se anuncia un servidor y cuando se olvida uno que dejo de anunciarse
+const (
+	DiscoveryPort     = 2047
+	DiscoveryInterval = 2 * time.Second
+	DiscoveryExpiry   = 3 * DiscoveryInterval
+)
+
+//DiscoveryGame tells our announcements apart from any other broadcast on DiscoveryPort
+const DiscoveryGame = "GameTheGame"
+
+//Announcement structure is broadcast on the LAN by every lobby server
+type Announcement struct {
+	Game   string
+	Name   string
+	Scheme string
+	Port   Integer
+	Rooms  Integer
+}
+
+//DiscoveredServer structure is a lobby server heard on the LAN
+type DiscoveredServer struct {
+	Announcement
+	Address  string
+	LastSeen time.Time
+}
+
+//Announce function broadcasts the lobby on the LAN every DiscoveryInterval until it stops serving.
+//scheme is the one clients must dial, tcp:// or tls://
+func (lobby *Lobby) Announce(port Integer, scheme string) {
+	connection, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4bcast, Port: DiscoveryPort})
+	if err != nil {
+		lobby.Log(fmt.Sprint("LAN announcements disabled: ", err))
+		return
+	}
+	defer connection.Close()
+	name, err := os.Hostname()
+	if err != nil {
+		name = "GameTheGame"
+	}
+	ticker := time.NewTicker(DiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		announcement := Announcement{Game: DiscoveryGame, Name: name, Scheme: scheme, Port: port, Rooms: Integer(len(lobby.RoomInfos()))}
+		connection.Write([]byte(StructToJSON(announcement)))
+		select {
+		case <-ticker.C:
+		case <-lobby.Done:
+			return
+		}
+	}
+}
+
+//ListenForAnnouncements function sends every announcement heard on the LAN to found until done is closed
+func ListenForAnnouncements(found chan<- DiscoveredServer, done <-chan struct{}) error {
+	connection, err := net.ListenUDP("udp4", &net.UDPAddr{Port: DiscoveryPort})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-done
+		connection.Close()
+	}()
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			size, address, err := connection.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			var server DiscoveredServer
+			if json.Unmarshal(buffer[:size], &server.Announcement) != nil || server.Game != DiscoveryGame {
+				continue
+			}
+			if server.Scheme != "tls://" {
+				server.Scheme = "tcp://"
+			}
+			server.Address = server.Scheme + net.JoinHostPort(address.IP.String(), strconv.Itoa(int(server.Port)))
+			server.LastSeen = time.Now()
+			select {
+			case found <- server:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+//ServerListString function numbers the discovered servers
+func ServerListString(servers []DiscoveredServer) string {
+	if len(servers) == 0 {
+		return "No games found yet, type \"manual\" to enter an address"
+	}
+	lines := []string{"Games on your network (type a number to join, or \"manual\"):"}
+	for index, server := range servers {
+		lines = append(lines, fmt.Sprintf("%d %s %s (%s, %d rooms)", index+1, string(tcell.RuneRArrow), server.Name, server.Address, server.Rooms))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//DiscoverServer function shows a live list of the lobby servers on the LAN and lets the player join one by number,
+//the list stays open if the connection fails. It returns false if the player prefers to type the address
+func (appManager *AppManager) DiscoverServer() bool {
+	found := make(chan DiscoveredServer)
+	done := make(chan struct{})
+	defer close(done)
+	if err := ListenForAnnouncements(found, done); err != nil {
+		appManager.WriteEntryAndUpdate(fmt.Sprint("LAN discovery is not available: ", err))
+		return false
+	}
+	appManager.WriteEntryAndUpdate("Searching for games on your network, type \"manual\" to enter an address instead")
+	servers := make(map[string]DiscoveredServer)
+	var list []DiscoveredServer
+	ticker := time.NewTicker(DiscoveryInterval)
+	defer ticker.Stop()
+	for {
+		changed := false
+		select {
+		case server := <-found:
+			_, known := servers[server.Address]
+			changed = !known || servers[server.Address].Rooms != server.Rooms
+			servers[server.Address] = server
+		case <-ticker.C:
+			for address, server := range servers {
+				if time.Since(server.LastSeen) > DiscoveryExpiry {
+					delete(servers, address)
+					changed = true
+				}
+			}
+		case command := <-appManager.CommandChannel:
+			if strings.EqualFold(string(command), "manual") {
+				return false
+			}
+			selection, err := strconv.Atoi(string(command))
+			if err != nil || selection <= 0 || selection > len(list) {
+				appManager.WriteEntryAndUpdate(ServerListString(list))
+				continue
+			}
+			appManager.ServerAddress = list[selection-1].Address
+			appManager.DialServer()
+			if appManager.Connection != nil {
+				return true
+			}
+			appManager.WriteEntryAndUpdate(ServerListString(list))
+		}
+		if changed {
+			list = list[:0]
+			for _, server := range servers {
+				list = append(list, server)
+			}
+			sort.Slice(list, func(i, j int) bool {
+				return list[i].Address < list[j].Address
+			})
+			appManager.WriteEntryAndUpdate(ServerListString(list))
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Valores por defecto del draft
+const (
+	DraftSeats     Integer = 4
+	DraftRounds    Integer = 3
+	DraftPackSize  Integer = 5
+	DraftPickTimer         = 30 * time.Second
+)
+
+//Draft structure holds the packs and pools of a booster draft.
+//Cards are indices of ArregloDeCartas. Every seat picks one card from its pack,
+//and once all seats have picked the packs are passed, to the left on even rounds
+//and to the right on odd rounds
+type Draft struct {
+	Seats    Integer
+	Rounds   Integer
+	PackSize Integer
+	Round    Integer
+	Packs    [][]Integer
+	Pools    [][]Integer
+	Picked   []bool
+	Random   *rand.Rand
+}
+
+//NewDraft function creates a draft and opens the packs of the first round
+func NewDraft(seats, rounds, packSize Integer, seed int64) *Draft {
+	var draft Draft
+	draft.Seats = seats
+	draft.Rounds = rounds
+	draft.PackSize = packSize
+	draft.Random = rand.New(rand.NewSource(seed))
+	draft.Pools = make([][]Integer, seats)
+	draft.OpenPacks()
+	return &draft
+}
+
+//OpenPacks function generates a new random pack for every seat
+func (draft *Draft) OpenPacks() {
+	draft.Packs = make([][]Integer, draft.Seats)
+	draft.Picked = make([]bool, draft.Seats)
+	for seat := range draft.Packs {
+		pack := make([]Integer, draft.PackSize)
+		for index := range pack {
+			pack[index] = Integer(draft.Random.Intn(len(ArregloDeCartas)))
+		}
+		draft.Packs[seat] = pack
+	}
+}
+
+//Finished function reports whether every round has been drafted
+func (draft *Draft) Finished() bool {
+	return draft.Round >= draft.Rounds
+}
+
+//Pick function moves the card at position index of the seat's pack into the seat's pool
+func (draft *Draft) Pick(seat, index Integer) error {
+	if draft.Finished() {
+		return errors.New("El draft ya termino")
+	}
+	if seat < 0 || seat >= draft.Seats {
+		return errors.New("El asiento " + strconv.Itoa(int(seat)) + " no existe")
+	}
+	if draft.Picked[seat] {
+		return errors.New("Ya escogiste una carta de este sobre")
+	}
+	pack := draft.Packs[seat]
+	if index < 0 || index >= Integer(len(pack)) {
+		return errors.New("Escoje un numero entre 1 y " + strconv.Itoa(len(pack)))
+	}
+	draft.Pools[seat] = append(draft.Pools[seat], pack[index])
+	draft.Packs[seat] = append(pack[:index:index], pack[index+1:]...)
+	draft.Picked[seat] = true
+	return nil
+}
+
+//AllPicked function reports whether every seat has picked from its current pack
+func (draft *Draft) AllPicked() bool {
+	for _, picked := range draft.Picked {
+		if !picked {
+			return false
+		}
+	}
+	return true
+}
+
+//Pass function hands every pack to the next seat, or opens the packs of the next round once they are empty
+func (draft *Draft) Pass() {
+	if len(draft.Packs[0]) == 0 {
+		draft.Round++
+		if !draft.Finished() {
+			draft.OpenPacks()
+		}
+		return
+	}
+	packs := make([][]Integer, draft.Seats)
+	for seat := Integer(0); seat < draft.Seats; seat++ {
+		next := (seat + 1) % draft.Seats
+		if draft.Round%2 == 1 {
+			next = (seat + draft.Seats - 1) % draft.Seats
+		}
+		packs[next] = draft.Packs[seat]
+	}
+	draft.Packs = packs
+	draft.Picked = make([]bool, draft.Seats)
+}
+
+//Deck function copies the pool of a seat into a deck of cards
+func (draft *Draft) Deck(seat Integer) []Card {
+	return NewStartingHand(draft.Pools[seat])
+}
+
+//CardScore function is the heuristic the draft bots use to rate a card
+func CardScore(card *Card) Integer {
+	return (card.RedDamage + card.BlueDamage + card.Healing + card.RedArmor + card.BlueArmor + card.Range - card.AntiAttackSpeed) / card.Cost
+}
+
+//BestPick function returns the position of the best card of a pack according to CardScore
+func BestPick(pack []Integer) Integer {
+	var best Integer
+	for index := range pack {
+		if CardScore(ArregloDeCartas[pack[index]]) > CardScore(ArregloDeCartas[pack[best]]) {
+			best = Integer(index)
+		}
+	}
+	return best
+}
+
+//ReadCommandWithTimeout function waits for a command at most timeout, ok is false when it expires
+func (appManager *AppManager) ReadCommandWithTimeout(timeout time.Duration) (command []rune, ok bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case command := <-appManager.CommandChannel:
+		return command, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+//ParseDraftPick function returns the position in pack of the card a command names, by number or by name
+func ParseDraftPick(command string, pack []Integer) (Integer, bool) {
+	command = strings.TrimSpace(command)
+	number, err := strconv.Atoi(command)
+	if err == nil && number > 0 && number <= len(pack) {
+		return Integer(number - 1), true
+	}
+	for index, card := range pack {
+		if strings.EqualFold(ArregloDeCartas[card].Name, command) {
+			return Integer(index), true
+		}
+	}
+	return 0, false
+}
+
+//AskDraftPick function shows a pack and reads the position of the chosen card before the pick timer runs out
+func (appManager *AppManager) AskDraftPick(draft *Draft, seat Integer) Integer {
+	pack := draft.Packs[seat]
+	entry := "Ronda " + strconv.Itoa(int(draft.Round+1)) + " de " + strconv.Itoa(int(draft.Rounds)) +
+		", escoje una carta (tienes " + DraftPickTimer.String() + "):"
+	for index, card := range pack {
+		entry += "\n" + strconv.Itoa(index+1) + ") " + ArregloDeCartas[card].Name
+	}
+	appManager.WriteEntryAndUpdate(entry)
+	deadline := time.Now().Add(DraftPickTimer)
+	for {
+		command, ok := appManager.ReadCommandWithTimeout(time.Until(deadline))
+		if !ok {
+			best := BestPick(pack)
+			appManager.WriteEntryAndUpdate("Se acabo el tiempo, escogimos " + ArregloDeCartas[pack[best]].Name + " por ti")
+			return best
+		}
+		if index, ok := ParseDraftPick(string(command), pack); ok {
+			return index
+		}
+		appManager.WriteEntryAndUpdate("Escribe un numero entre 1 y " + strconv.Itoa(len(pack)) + " o el nombre de la carta")
+	}
+}
+
+//PlayDraft function drafts a deck in seat 0 against bots in the other seats
+func (appManager *AppManager) PlayDraft() {
+	draft := NewDraft(DraftSeats, DraftRounds, DraftPackSize, time.Now().UnixNano())
+	appManager.WriteEntry("Draft contra " + strconv.Itoa(int(DraftSeats-1)) + " bots: escoje una carta de cada sobre y pasa el resto")
+	for !draft.Finished() {
+		draft.Pick(0, appManager.AskDraftPick(draft, 0))
+		for seat := Integer(1); seat < draft.Seats; seat++ {
+			draft.Pick(seat, BestPick(draft.Packs[seat]))
+		}
+		draft.Pass()
+	}
+	appManager.Player.Deck = draft.Deck(0)
+	summary := "Tu mazo:"
+	for _, card := range appManager.Player.Deck {
+		summary += "\n" + card.Name
+	}
+	appManager.WriteEntryAndUpdate(summary)
+}
+
+//RunDraftRoom function drafts the decks of a full draft room. Its players pick from their packs at the same time
+//and bots take the other seats of the table. The server picks with BestPick for a player that does not pick
+//within DraftPickTimer or that is gone, a draft can not be resumed. Every player that stays gets its pool as DraftDeckMessage
+func (lobby *Lobby) RunDraftRoom(room *Room) {
+	defer func() {
+		lobby.Mutex.Lock()
+		lobby.closeRoom(room)
+		lobby.Mutex.Unlock()
+	}()
+	lobby.Log("Draft started in room " + room.Name)
+	draft := NewDraft(DraftSeats, DraftRounds, DraftPackSize, time.Now().UnixNano())
+	players := Integer(len(room.Clients))
+	pick := func(seat, index Integer, automatic bool) {
+		card := draft.Packs[seat][index]
+		draft.Pick(seat, index)
+		if seat < players && room.Clients[seat] != nil {
+			room.Clients[seat].Connection.Send(&DraftPickedMessage{Card: card, Automatic: automatic})
+		}
+	}
+	for !draft.Finished() {
+		for seat := Integer(0); seat < draft.Seats; seat++ {
+			if seat < players && room.Clients[seat] != nil {
+				room.Clients[seat].Connection.Send(&DraftPackMessage{Round: draft.Round, Rounds: draft.Rounds, Pack: draft.Packs[seat], PickTimer: Integer(DraftPickTimer / time.Second)})
+			} else {
+				pick(seat, BestPick(draft.Packs[seat]), true)
+			}
+		}
+		timer := time.NewTimer(DraftPickTimer)
+		for !draft.AllPicked() {
+			var roomMessage RoomMessage
+			select {
+			case <-timer.C:
+				for seat := Integer(0); seat < players; seat++ {
+					if !draft.Picked[seat] {
+						pick(seat, BestPick(draft.Packs[seat]), true)
+					}
+				}
+				continue
+			case roomMessage = <-room.Inbox:
+			}
+			client := roomMessage.Client
+			switch message := roomMessage.Message.(type) {
+			case nil, *LeaveRoomMessage:
+				seat := client.Seat
+				if room.Clients[seat] != client {
+					continue
+				}
+				lobby.Mutex.Lock()
+				room.Clients[seat] = nil
+				client.Room = nil
+				lobby.Mutex.Unlock()
+				room.Broadcast(&PlayerLeftMessage{Seat: seat})
+				lobby.Log("A player left the draft in room " + room.Name)
+				if !draft.Picked[seat] {
+					pick(seat, BestPick(draft.Packs[seat]), true)
+				}
+			case *DraftPickMessage:
+				seat := client.Seat
+				if room.Clients[seat] != client {
+					continue
+				}
+				if draft.Picked[seat] {
+					client.Connection.Send(&ErrorMessage{Reason: "you already picked a card of this pack"})
+					continue
+				}
+				if message.Index >= Integer(len(draft.Packs[seat])) {
+					client.Connection.Send(&ErrorMessage{Reason: "pick a number between 1 and " + strconv.Itoa(len(draft.Packs[seat]))})
+					continue
+				}
+				pick(seat, message.Index, false)
+			case *ResumeMessage:
+				client.Connection.Send(&ErrorMessage{Reason: "a draft can not be resumed"})
+			case *RoomClosedMessage:
+				timer.Stop()
+				room.Broadcast(message)
+				lobby.Log("Room " + room.Name + " " + message.Reason)
+				return
+			default:
+				client.Connection.Send(&ErrorMessage{Reason: "the draft only takes picks"})
+			}
+		}
+		timer.Stop()
+		draft.Pass()
+	}
+	for seat, client := range room.Clients {
+		if client != nil {
+			client.Connection.Send(&DraftDeckMessage{Cards: draft.Pools[seat]})
+		}
+	}
+	lobby.Log("Draft finished in room " + room.Name)
+}
+
+//DraftPackString function describes a pack of a draft room
+func DraftPackString(message *DraftPackMessage) string {
+	entry := fmt.Sprintf("Round %d of %d, pick a card within %d seconds:", message.Round+1, message.Rounds, message.PickTimer)
+	for index, card := range message.Pack {
+		entry += "\n" + strconv.Itoa(index+1) + ") " + ArregloDeCartas[card].Name
+	}
+	return entry
+}
+
+//PlayDraftRoom function drafts a deck in the joined draft room starting with its first pack, the drafted deck becomes the deck of the player.
+//It returns false if the connection was lost or closed by the server
+func (appManager *AppManager) PlayDraftRoom(pack *DraftPackMessage) bool {
+	appManager.WriteEntry("The draft started, pick a card of every pack and pass the rest, type \"leave\" at any moment to quit it")
+	appManager.WriteEntryAndUpdate(DraftPackString(pack))
+	for {
+		select {
+		case command := <-appManager.CommandChannel:
+			if appManager.HandleChatCommand(string(command)) {
+				continue
+			}
+			if strings.EqualFold(string(command), "leave") {
+				appManager.SendMessage(&LeaveRoomMessage{})
+				appManager.WriteEntryAndUpdate("You left the draft")
+				return true
+			}
+			if pack == nil {
+				appManager.WriteEntryAndUpdate("Wait for the next pack")
+				continue
+			}
+			index, ok := ParseDraftPick(string(command), pack.Pack)
+			if !ok {
+				appManager.WriteEntryAndUpdate("Type a number between 1 and " + strconv.Itoa(len(pack.Pack)) + " or the name of the card")
+				continue
+			}
+			appManager.SendMessage(&DraftPickMessage{Index: index})
+		case message, ok := <-appManager.Connection.Incoming:
+			if !ok {
+				appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", appManager.Connection.Err()))
+				return false
+			}
+			switch message := message.(type) {
+			case *DraftPackMessage:
+				pack = message
+				appManager.WriteEntryAndUpdate(DraftPackString(pack))
+			case *DraftPickedMessage:
+				pack = nil
+				if message.Automatic {
+					appManager.WriteEntryAndUpdate("Time is up, the server picked " + ArregloDeCartas[message.Card].Name + " for you")
+				} else {
+					appManager.WriteEntryAndUpdate("You picked " + ArregloDeCartas[message.Card].Name + ", waiting for the other players")
+				}
+			case *DraftDeckMessage:
+				appManager.Player = &Player{Credit: StartingCredit, Deck: NewStartingHand(message.Cards)}
+				summary := "The draft finished, your deck:"
+				for _, card := range appManager.Player.Deck {
+					summary += "\n" + card.Name
+				}
+				appManager.WriteEntryAndUpdate(summary)
+				return true
+			case *PlayerLeftMessage:
+				appManager.WriteEntryAndUpdate("A player left the draft, the server picks for their seat")
+			case *RoomClosedMessage:
+				appManager.WriteEntryAndUpdate("The draft ended, the room was " + message.Reason)
+				return true
+			case *ChatMessage:
+				appManager.ShowChat(message)
+			case *NameRegisteredMessage:
+				appManager.SaveIdentity(message)
+			case *ErrorMessage:
+				appManager.WriteEntryAndUpdate("Error: " + message.Reason)
+				if strings.HasPrefix(message.Reason, DisconnectedReason) {
+					return false
+				}
+			}
+		}
+	}
+}
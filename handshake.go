@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//ProtocolVersion changes whenever messages or rules change in a way older builds can not follow
+const ProtocolVersion Integer = 1
+
+//BuildVersion identifies the build in the hello, set it with -ldflags "-X main.BuildVersion=..."
+var BuildVersion = "dev"
+
+//Funciones opcionales que esta version soporta, cada conexion usa solo las que soportan ambos lados
+const (
+	ChatFeature        = "chat"
+	DraftFeature       = "draft"
+	LockstepFeature    = "lockstep"
+	MatchmakingFeature = "matchmaking"
+	ReconnectFeature   = "reconnect"
+	TournamentFeature  = "tournament"
+)
+
+//SupportedFeatures lists the optional features of this build
+var SupportedFeatures = []string{ChatFeature, DraftFeature, LockstepFeature, MatchmakingFeature, ReconnectFeature, TournamentFeature}
+
+//HelloMessage structure is the first message both peers send on a connection
+type HelloMessage struct {
+	ProtocolVersion Integer
+	BuildVersion    string
+	CatalogHash     string
+	Features        []string
+}
+
+//MessageType function
+func (message *HelloMessage) MessageType() string {
+	return "hello"
+}
+
+//CatalogHash function returns the SHA-256 of the card catalog and the rules of the match,
+//two builds with different cards or rules have different hashes
+func CatalogHash() string {
+	rules := struct {
+		Cards            []*Card
+		StartingHandSize Integer
+		StartingCredit   Integer
+		BoardRows        Integer
+		BoardColumns     Integer
+	}{ArregloDeCartas, StartingHandSize, StartingCredit, BoardRows, BoardColumns}
+	sum := sha256.Sum256([]byte(StructToJSON(rules)))
+	return hex.EncodeToString(sum[:])
+}
+
+//NewHelloMessage function describes this build
+func NewHelloMessage() *HelloMessage {
+	return &HelloMessage{ProtocolVersion: ProtocolVersion, BuildVersion: BuildVersion, CatalogHash: CatalogHash(), Features: SupportedFeatures}
+}
+
+//Negotiate function checks that the hello of the peer is compatible with this build and returns the features both support
+func Negotiate(hello *HelloMessage) (map[string]bool, error) {
+	if hello.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("incompatible versions: the peer speaks protocol %d (build %s) and this build speaks protocol %d (build %s), update the older one",
+			hello.ProtocolVersion, hello.BuildVersion, ProtocolVersion, BuildVersion)
+	}
+	if hello.CatalogHash != CatalogHash() {
+		return nil, fmt.Errorf("incompatible versions: the peer (build %s) has different cards or rules than this build (build %s), both need the same version of the game",
+			hello.BuildVersion, BuildVersion)
+	}
+	features := make(map[string]bool)
+	for _, feature := range hello.Features {
+		for _, supported := range SupportedFeatures {
+			if feature == supported {
+				features[feature] = true
+			}
+		}
+	}
+	return features, nil
+}
+
+//Handshake function sends the hello of this build and waits for the hello of the peer.
+//On success Features holds the features both peers support
+func (connection *Connection) Handshake() error {
+	if err := connection.Send(NewHelloMessage()); err != nil {
+		return err
+	}
+	select {
+	case message, ok := <-connection.Incoming:
+		if !ok {
+			return connection.Err()
+		}
+		switch message := message.(type) {
+		case *HelloMessage:
+			features, err := Negotiate(message)
+			if err != nil {
+				return err
+			}
+			connection.Features = features
+			return nil
+		case *ErrorMessage:
+			return errors.New(message.Reason)
+		}
+		return errors.New("the peer did not start with a hello, it is probably an older build")
+	case <-time.After(DeadPeerTimeout):
+		return errors.New("the peer did not answer the hello")
+	}
+}
+
+//HasFeature function reports whether both peers support feature
+func (connection *Connection) HasFeature(feature string) bool {
+	return connection.Features[feature]
+}
+
+//FeaturesString function lists the shared features
+func (connection *Connection) FeaturesString() string {
+	var features []string
+	for feature := range connection.Features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return fmt.Sprint(features)
+}
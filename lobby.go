@@ -0,0 +1,742 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//RoomSeats is the number of players a room needs to start its match
+const RoomSeats Integer = 2
+
+//Tiempo que se guarda el asiento de un jugador desconectado y cada cuanto intenta reconectarse
+const (
+	ReconnectGracePeriod = 60 * time.Second
+	ReconnectInterval    = 2 * time.Second
+)
+
+//ShutdownTimeout is how long Shutdown waits for the rooms to close and for the last messages to be written
+const ShutdownTimeout = 5 * time.Second
+
+//Lobby structure accepts clients and keeps the named rooms they play in.
+//Raw TCP clients and HTTP requests, WebSockets among them, share Listener
+type Lobby struct {
+	Listener     net.Listener
+	HTTPListener *ConnListener
+	Mux          *http.ServeMux
+	Log          func(string)
+	Mutex        sync.Mutex
+	Rooms        map[string]*Room
+	Clients      map[*Client]bool
+	Sessions     map[string]*Room
+	Guests       Integer
+	MatchQueue   []*QueueEntry
+	Ratings      *Ratings
+	Accounts     *Accounts
+	Tournaments  map[string]*Tournament
+	Metrics      *Metrics
+	Started      time.Time
+	Done         chan struct{}
+	shutdown     bool
+	running      sync.WaitGroup
+}
+
+//Client structure is a connection served by the lobby. Room, Seat, Name and the matchmaking fields are guarded by the lobby mutex.
+//Named is false while the client keeps its guest name, Queued is true while it waits in the queue or for its Proposal.
+//Strikes counts its invalid messages and Kicked is set once it is disconnected, only ServeClient uses them
+type Client struct {
+	Connection  *Connection
+	Room        *Room
+	Seat        Integer
+	Name        string
+	Named       bool
+	Queued      bool
+	Proposal    *Proposal
+	ChatLimiter *RateLimiter
+	Strikes     Integer
+	Kicked      bool
+}
+
+//Room structure is a named room. Once it is full its match runs in its own goroutine,
+//which receives the messages of the seated clients through Inbox.
+//Tokens holds the session token of every seat, a nil client is a seat waiting for its player to reconnect.
+//Names holds the name of every seat, the result of a Ranked room updates their ratings
+//and the result of a room of a Tournament is recorded in its Pairing. A Draft room runs RunDraftRoom instead of a match
+type Room struct {
+	Name       string
+	Locked     bool
+	Ranked     bool
+	Draft      bool
+	Tournament *Tournament
+	Pairing    *Pairing
+	Password   [sha256.Size]byte
+	Clients    []*Client
+	Names      []string
+	Tokens     []string
+	Events     []Message
+	Playing    bool
+	Inbox      chan RoomMessage
+	done       chan struct{}
+}
+
+//RoomMessage structure is a message from a client of the room, a nil Message means the client disconnected
+type RoomMessage struct {
+	Client  *Client
+	Message Message
+}
+
+//NewLobby function creates a lobby that will serve the clients of listener
+func NewLobby(listener net.Listener, log func(string)) *Lobby {
+	var lobby Lobby
+	lobby.Listener = listener
+	lobby.Log = log
+	lobby.Rooms = make(map[string]*Room)
+	lobby.Clients = make(map[*Client]bool)
+	lobby.Sessions = make(map[string]*Room)
+	lobby.Ratings = LoadRatings(RatingsFile)
+	lobby.Accounts = LoadAccounts(AccountsFile)
+	lobby.Tournaments = make(map[string]*Tournament)
+	lobby.Metrics = &Metrics{}
+	lobby.Started = time.Now()
+	lobby.Done = make(chan struct{})
+	lobby.HTTPListener = NewConnListener(listener.Addr())
+	lobby.Mux = http.NewServeMux()
+	lobby.Mux.HandleFunc(WebSocketPath, lobby.ServeWebSocket)
+	return &lobby
+}
+
+//Serve function accepts clients until the listener is closed, then closes Done
+func (lobby *Lobby) Serve() error {
+	defer close(lobby.Done)
+	defer lobby.HTTPListener.Close()
+	go http.Serve(lobby.HTTPListener, lobby.Mux)
+	go lobby.RunMatchmaking()
+	for {
+		conn, err := lobby.Listener.Accept()
+		if err != nil {
+			return err
+		}
+		go lobby.Route(conn)
+	}
+}
+
+//Route function peeks at the first byte of a connection: HTTP requests start with an upper case method,
+//while our frames start with the high byte of their length, which is always 0
+func (lobby *Lobby) Route(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(DeadPeerTimeout))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	buffered := &BufferedConn{Conn: conn, Reader: reader}
+	if first[0] >= 'A' && first[0] <= 'Z' {
+		lobby.HTTPListener.Push(buffered)
+		return
+	}
+	lobby.ServeClient(NewLimitedConnection(&CountingConn{Conn: buffered, metrics: lobby.Metrics}, ClientLimits))
+}
+
+//ServeWebSocket function serves a client that connects through a WebSocket at WebSocketPath
+func (lobby *Lobby) ServeWebSocket(writer http.ResponseWriter, request *http.Request) {
+	conn, err := WebSocketUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		lobby.Log(fmt.Sprint("WebSocket error: ", err))
+		return
+	}
+	lobby.ServeClient(NewLimitedConnection(&CountingConn{Conn: NewWebSocketConn(conn), metrics: lobby.Metrics}, ClientLimits))
+}
+
+//ServeClient function handles the messages of a client until it disconnects
+func (lobby *Lobby) ServeClient(connection *Connection) {
+	if err := connection.Handshake(); err != nil {
+		lobby.Log("Rejected client from " + connection.Conn.RemoteAddr().String() + ": " + err.Error())
+		connection.Reject("the server rejected the connection: " + err.Error())
+		return
+	}
+	client := &Client{Connection: connection, ChatLimiter: NewRateLimiter(ChatBurst, ChatRefill)}
+	atomic.AddInt64(&lobby.Metrics.Connections, 1)
+	lobby.Mutex.Lock()
+	lobby.Guests++
+	client.Name = fmt.Sprint(GuestPrefix, lobby.Guests)
+	lobby.Clients[client] = true
+	lobby.Mutex.Unlock()
+	lobby.Log("Client connected from " + connection.Conn.RemoteAddr().String() + " with features " + connection.FeaturesString())
+	for message := range connection.Incoming {
+		atomic.AddInt64(&lobby.Metrics.MessagesReceived, 1)
+		if lobby.Screen(client, message) {
+			lobby.HandleClientMessage(client, message)
+		}
+	}
+	lobby.Log("Client " + connection.Conn.RemoteAddr().String() + " disconnected: " + connection.Err().Error())
+	lobby.LeaveQueue(client)
+	lobby.LeaveRoom(client, nil)
+	lobby.Mutex.Lock()
+	delete(lobby.Clients, client)
+	lobby.Mutex.Unlock()
+}
+
+//Shutdown function stops accepting clients and closes every room, so their players are told and no match records a result.
+//It waits up to ShutdownTimeout for the matches and drafts to stop and then closes every connection once its messages were written
+func (lobby *Lobby) Shutdown() {
+	deadline := time.Now().Add(ShutdownTimeout)
+	lobby.Mutex.Lock()
+	lobby.shutdown = true
+	lobby.Listener.Close()
+	var names []string
+	for name := range lobby.Rooms {
+		names = append(names, name)
+	}
+	lobby.Mutex.Unlock()
+	for _, name := range names {
+		go lobby.CloseRoom(name, "closed because the server is shutting down")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		lobby.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		lobby.Log("Some rooms did not close in " + ShutdownTimeout.String())
+	}
+	lobby.Mutex.Lock()
+	var connections []*Connection
+	for client := range lobby.Clients {
+		connections = append(connections, client.Connection)
+	}
+	lobby.Mutex.Unlock()
+	var drained sync.WaitGroup
+	for _, connection := range connections {
+		drained.Add(1)
+		go func(connection *Connection) {
+			defer drained.Done()
+			connection.Drain(time.Until(deadline))
+		}(connection)
+	}
+	drained.Wait()
+}
+
+//ShuttingDown function reports whether Shutdown was called
+func (lobby *Lobby) ShuttingDown() bool {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	return lobby.shutdown
+}
+
+//HandleClientMessage function answers lobby requests and forwards everything else to the client's match.
+//Nothing is forwarded to a room that is still waiting for players, no match reads its Inbox yet
+func (lobby *Lobby) HandleClientMessage(client *Client, message Message) {
+	var err error
+	switch message := message.(type) {
+	case *ListRoomsMessage:
+		err = client.Connection.Send(&RoomsMessage{Rooms: lobby.RoomInfos()})
+	case *CreateRoomMessage:
+		err = lobby.CreateRoom(client, strings.TrimSpace(message.Name), message.Password, message.Draft)
+	case *JoinRoomMessage:
+		err = lobby.JoinRoom(client, strings.TrimSpace(message.Name), message.Password)
+	case *LeaveRoomMessage:
+		lobby.LeaveRoom(client, message)
+	case *SetNameMessage:
+		err = lobby.SetName(client, message.Name, message.Secret, message.Register)
+	case *ChatMessage:
+		err = lobby.HandleChat(client, message)
+	case *QueueMessage:
+		err = lobby.Queue(client)
+	case *LeaveQueueMessage:
+		lobby.LeaveQueue(client)
+	case *ConfirmMatchMessage:
+		err = lobby.ConfirmMatch(client, message.Accept)
+	case *TournamentMessage:
+		err = lobby.HandleTournament(client, message)
+	case *ResumeMessage:
+		lobby.Mutex.Lock()
+		room := lobby.Sessions[message.Token]
+		playing := room != nil && room.Playing
+		lobby.Mutex.Unlock()
+		if room != nil && !playing {
+			err = errors.New("the match has not started")
+		} else if room == nil || !room.Send(RoomMessage{Client: client, Message: message}) {
+			err = errors.New("the session expired")
+		}
+	default:
+		lobby.Mutex.Lock()
+		room := client.Room
+		playing := room != nil && room.Playing
+		lobby.Mutex.Unlock()
+		if room != nil && !playing {
+			err = errors.New("the match has not started")
+		} else if room == nil || !room.Send(RoomMessage{Client: client, Message: message}) {
+			err = errors.New("you are not playing a match")
+		}
+	}
+	if err != nil {
+		client.Connection.Send(&ErrorMessage{Reason: err.Error()})
+	}
+}
+
+//RoomInfos function describes every room sorted by name
+func (lobby *Lobby) RoomInfos() []RoomInfo {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	var infos []RoomInfo
+	for _, room := range lobby.Rooms {
+		infos = append(infos, RoomInfo{Name: room.Name, Players: Integer(len(room.Clients)), Playing: room.Playing, Locked: room.Locked, Draft: room.Draft})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+//CreateRoom function creates a room and seats the client in it, the room asks for password if it is not empty
+//and drafts decks instead of playing a match if draft is true
+func (lobby *Lobby) CreateRoom(client *Client, name, password string, draft bool) error {
+	if name == "" {
+		return errors.New("the room needs a name")
+	}
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if client.Room != nil {
+		return errors.New("you are already in room " + client.Room.Name)
+	}
+	if client.Queued {
+		return errors.New("leave the matchmaking queue first")
+	}
+	if lobby.Rooms[name] != nil {
+		return errors.New("room " + name + " already exists")
+	}
+	if draft && !client.Connection.HasFeature(DraftFeature) {
+		return errors.New("your build does not support draft rooms")
+	}
+	room := &Room{Name: name, Draft: draft, Inbox: make(chan RoomMessage), done: make(chan struct{})}
+	if password != "" {
+		room.Locked = true
+		room.Password = sha256.Sum256([]byte(password))
+	}
+	lobby.Rooms[name] = room
+	lobby.seat(client, room)
+	lobby.Log("Room " + name + " created")
+	return nil
+}
+
+//JoinRoom function seats the client in a room and starts its match once the room is full
+func (lobby *Lobby) JoinRoom(client *Client, name, password string) error {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if client.Room != nil {
+		return errors.New("you are already in room " + client.Room.Name)
+	}
+	if client.Queued {
+		return errors.New("leave the matchmaking queue first")
+	}
+	room := lobby.Rooms[name]
+	if room == nil {
+		return errors.New("room " + name + " does not exist")
+	}
+	if Integer(len(room.Clients)) >= RoomSeats {
+		return errors.New("room " + name + " is full")
+	}
+	if room.Locked {
+		hash := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(hash[:], room.Password[:]) != 1 {
+			lobby.Log("Wrong password for room " + name)
+			return errors.New("wrong password for room " + name)
+		}
+	}
+	if room.Draft && !client.Connection.HasFeature(DraftFeature) {
+		return errors.New("your build does not support draft rooms")
+	}
+	lobby.seat(client, room)
+	if Integer(len(room.Clients)) == RoomSeats {
+		lobby.startRoom(room)
+	}
+	return nil
+}
+
+//startRoom function runs the match or the draft of a full room, Shutdown waits for it.
+//A room filled while the lobby shuts down is closed instead. The lobby mutex must be held
+func (lobby *Lobby) startRoom(room *Room) {
+	if lobby.shutdown {
+		room.Broadcast(&RoomClosedMessage{Reason: "closed because the server is shutting down"})
+		lobby.closeRoom(room)
+		return
+	}
+	room.Playing = true
+	lobby.running.Add(1)
+	go func() {
+		defer lobby.running.Done()
+		if room.Draft {
+			lobby.RunDraftRoom(room)
+		} else {
+			lobby.RunRoom(room)
+		}
+	}()
+}
+
+func (lobby *Lobby) seat(client *Client, room *Room) {
+	token := NewSessionToken()
+	client.Room = room
+	client.Seat = Integer(len(room.Clients))
+	room.Clients = append(room.Clients, client)
+	room.Names = append(room.Names, client.Name)
+	room.Tokens = append(room.Tokens, token)
+	lobby.Sessions[token] = room
+	client.Connection.Send(&JoinedRoomMessage{Name: room.Name, Token: token, Draft: room.Draft})
+}
+
+//NewSessionToken function returns a random hexadecimal token
+func NewSessionToken() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+//LeaveRoom function takes the client out of its room. During a match a LeaveRoomMessage forfeits it,
+//while a nil message, sent when the connection drops, keeps the seat for ReconnectGracePeriod
+func (lobby *Lobby) LeaveRoom(client *Client, message Message) {
+	lobby.Mutex.Lock()
+	room := client.Room
+	playing := room != nil && room.Playing
+	if room != nil && !playing {
+		lobby.closeRoom(room)
+	}
+	lobby.Mutex.Unlock()
+	if playing {
+		room.Send(RoomMessage{Client: client, Message: message})
+	}
+}
+
+//closeRoom function removes a room and frees its clients, the lobby mutex must be held
+func (lobby *Lobby) closeRoom(room *Room) {
+	if lobby.Rooms[room.Name] != room {
+		return
+	}
+	delete(lobby.Rooms, room.Name)
+	for _, client := range room.Clients {
+		if client != nil {
+			client.Room = nil
+		}
+	}
+	for _, token := range room.Tokens {
+		delete(lobby.Sessions, token)
+	}
+	close(room.done)
+	lobby.Log("Room " + room.Name + " closed")
+}
+
+//Send function hands a message to the match of the room, it returns false if the room is already closed
+func (room *Room) Send(message RoomMessage) bool {
+	select {
+	case room.Inbox <- message:
+		return true
+	case <-room.done:
+		return false
+	}
+}
+
+//Broadcast function sends a message to every client of the room
+func (room *Room) Broadcast(message Message) {
+	for _, client := range room.Clients {
+		if client != nil {
+			client.Connection.Send(message)
+		}
+	}
+}
+
+//BroadcastEvent function sends every client of the room the event as its seat may see it
+func (room *Room) BroadcastEvent(event Message) {
+	for seat, client := range room.Clients {
+		if client != nil {
+			client.Connection.Send(RedactEvent(event, Integer(seat)))
+		}
+	}
+}
+
+//RunRoom function plays the match of a full room, validating the intents of its clients, until it ends.
+//A disconnected seat is forfeited if its player does not resume the session within ReconnectGracePeriod
+func (lobby *Lobby) RunRoom(room *Room) {
+	timers := make(map[Integer]*time.Timer)
+	snapshots := make(map[Integer]*Match)
+	missed := make(map[Integer]int)
+	disconnected := make(map[Integer]time.Time)
+	expired := make(chan Integer)
+	winner := Integer(-1)
+	aborted := false
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+		if room.Ranked && !aborted {
+			lobby.RecordResult(room, winner)
+		}
+		lobby.Mutex.Lock()
+		lobby.closeRoom(room)
+		lobby.Mutex.Unlock()
+		if room.Tournament != nil {
+			lobby.RecordTournamentResult(room, winner)
+		}
+	}()
+	lobby.Log("Match started in room " + room.Name)
+	lobby.Metrics.MatchStarted(time.Now())
+	match := NewMatch(RoomSeats)
+	for seat, client := range room.Clients {
+		client.Connection.Send(&MatchStartMessage{Seat: Integer(seat)})
+	}
+	for match.Phase != FinishedPhase {
+		var roomMessage RoomMessage
+		select {
+		case seat := <-expired:
+			//The timer of an earlier disconnection may fire after the player reconnected and dropped again
+			if timers[seat] == nil || time.Since(disconnected[seat]) < ReconnectGracePeriod {
+				continue
+			}
+			room.Broadcast(&PlayerLeftMessage{Seat: seat})
+			lobby.Log("A player did not reconnect in time to room " + room.Name)
+			winner = 1 - seat
+			return
+		case roomMessage = <-room.Inbox:
+		}
+		client := roomMessage.Client
+		switch message := roomMessage.Message.(type) {
+		case nil:
+			seat := client.Seat
+			if room.Clients[seat] != client {
+				continue
+			}
+			lobby.Mutex.Lock()
+			room.Clients[seat] = nil
+			lobby.Mutex.Unlock()
+			snapshots[seat] = match.Clone()
+			missed[seat] = len(room.Events)
+			disconnected[seat] = time.Now()
+			timers[seat] = time.AfterFunc(ReconnectGracePeriod, func() {
+				select {
+				case expired <- seat:
+				case <-room.done:
+				}
+			})
+			room.Broadcast(&PlayerDisconnectedMessage{Seat: seat})
+			lobby.Log("A player lost the connection in room " + room.Name)
+		case *ResumeMessage:
+			seat := Integer(-1)
+			for index, token := range room.Tokens {
+				if token == message.Token {
+					seat = Integer(index)
+				}
+			}
+			if seat < 0 {
+				client.Connection.Send(&ErrorMessage{Reason: "the session expired"})
+				continue
+			}
+			lobby.Mutex.Lock()
+			previous := room.Clients[seat]
+			room.Clients[seat] = client
+			client.Room = room
+			client.Seat = seat
+			lobby.Mutex.Unlock()
+			if previous != nil {
+				previous.Connection.Close()
+				snapshots[seat] = match.Clone()
+				missed[seat] = len(room.Events)
+			}
+			if timers[seat] != nil {
+				timers[seat].Stop()
+				delete(timers, seat)
+			}
+			client.Connection.Send(&SnapshotMessage{Seat: seat, Match: snapshots[seat].ViewFor(seat)})
+			for _, event := range room.Events[missed[seat]:] {
+				client.Connection.Send(RedactEvent(event, seat))
+			}
+			room.Broadcast(&PlayerReconnectedMessage{Seat: seat})
+			lobby.Log("A player reconnected to room " + room.Name)
+		case *LeaveRoomMessage:
+			if room.Clients[client.Seat] != client {
+				continue
+			}
+			room.Broadcast(&PlayerLeftMessage{Seat: client.Seat})
+			lobby.Log("A player left the match in room " + room.Name)
+			winner = 1 - client.Seat
+			return
+		case *RoomClosedMessage:
+			room.Broadcast(message)
+			lobby.Log("Room " + room.Name + " " + message.Reason)
+			aborted = true
+			return
+		default:
+			if room.Clients[client.Seat] != client {
+				continue
+			}
+			events, err := match.Apply(client.Seat, message)
+			if err != nil {
+				client.Connection.Send(&ErrorMessage{Reason: err.Error()})
+				continue
+			}
+			for _, event := range events {
+				room.Events = append(room.Events, event)
+				room.BroadcastEvent(event)
+			}
+		}
+	}
+	lobby.Log("Match finished in room " + room.Name)
+}
+
+//PlayLobby function is the client side of the lobby: it sends lobby commands and plays the matches of the joined rooms
+func (appManager *AppManager) PlayLobby() {
+	if appManager.PlayerName == "" {
+		appManager.AskPlayerName()
+	} else {
+		appManager.SendName(false)
+	}
+	appManager.WriteEntryAndUpdate("Lobby commands: list, create <room> [password], draft <room> [password], join <room> [password], leave, queue, unqueue")
+	appManager.WriteEntryAndUpdate("Tournament commands: tournaments, tournament create <name> single|double|swiss, tournament join|start|show <name>")
+	appManager.WriteEntryAndUpdate("Chat commands: /say <message>, /mute [player], /name <name>, /register")
+	for {
+		select {
+		case command := <-appManager.CommandChannel:
+			if appManager.HandleChatCommand(string(command)) {
+				continue
+			}
+			fields := strings.Fields(string(command))
+			if len(fields) == 0 {
+				continue
+			}
+			var name, password string
+			if len(fields) > 1 {
+				name = fields[1]
+			}
+			if len(fields) > 2 {
+				password = fields[2]
+			}
+			var request Message
+			switch strings.ToLower(fields[0]) {
+			case "list":
+				request = &ListRoomsMessage{}
+			case "create":
+				request = &CreateRoomMessage{Name: name, Password: password}
+			case "draft":
+				if !appManager.Connection.HasFeature(DraftFeature) {
+					appManager.WriteEntryAndUpdate("This server does not support draft rooms")
+					continue
+				}
+				request = &CreateRoomMessage{Name: name, Password: password, Draft: true}
+			case "join":
+				request = &JoinRoomMessage{Name: name, Password: password}
+			case "leave":
+				request = &LeaveRoomMessage{}
+			case "queue":
+				if !appManager.Connection.HasFeature(MatchmakingFeature) {
+					appManager.WriteEntryAndUpdate("This server does not support matchmaking")
+					continue
+				}
+				request = &QueueMessage{}
+			case "unqueue":
+				request = &LeaveQueueMessage{}
+			case "accept":
+				request = &ConfirmMatchMessage{Accept: true}
+			case "decline":
+				request = &ConfirmMatchMessage{Accept: false}
+			case "tournaments", "tournament":
+				if !appManager.Connection.HasFeature(TournamentFeature) {
+					appManager.WriteEntryAndUpdate("This server does not support tournaments")
+					continue
+				}
+				request = ParseTournamentCommand(fields)
+				if request == nil {
+					appManager.WriteEntryAndUpdate("Use: tournaments, tournament create <name> single|double|swiss, tournament join|start|show <name>")
+					continue
+				}
+			default:
+				appManager.WriteEntryAndUpdate("Unknown command, use: list, create <room> [password], draft <room> [password], join <room> [password], leave, queue, unqueue")
+				continue
+			}
+			if !appManager.ValidateRequest(request) {
+				continue
+			}
+			if err := appManager.Connection.Send(request); err != nil {
+				appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", err))
+				return
+			}
+		case message, ok := <-appManager.Connection.Incoming:
+			if !ok {
+				appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", appManager.Connection.Err()))
+				return
+			}
+			switch message := message.(type) {
+			case *RoomsMessage:
+				if len(message.Rooms) == 0 {
+					appManager.WriteEntryAndUpdate("There are no rooms, create one with: create <room>")
+					continue
+				}
+				entry := "Rooms:"
+				for _, room := range message.Rooms {
+					state := "waiting"
+					if room.Playing {
+						state = "playing"
+					}
+					if room.Locked {
+						state += ", password"
+					}
+					if room.Draft {
+						state = "draft, " + state
+					}
+					entry += fmt.Sprintf("\n%s (%d/%d players, %s)", room.Name, room.Players, RoomSeats, state)
+				}
+				appManager.WriteEntryAndUpdate(entry)
+			case *JoinedRoomMessage:
+				appManager.SessionToken = message.Token
+				if message.Draft {
+					appManager.WriteEntryAndUpdate("You are in draft room " + message.Name + ", the draft starts when it is full")
+					continue
+				}
+				appManager.WriteEntryAndUpdate("You are in room " + message.Name + ", the match starts when it is full")
+			case *DraftPackMessage:
+				if !appManager.PlayDraftRoom(message) {
+					return
+				}
+				appManager.WriteEntryAndUpdate("Back in the lobby")
+			case *MatchStartMessage:
+				if !appManager.PlayMatch(message.Seat) {
+					return
+				}
+				appManager.WriteEntryAndUpdate("Back in the lobby")
+			case *QueuedMessage:
+				if message.Reason != "" {
+					appManager.WriteEntry(strings.ToUpper(message.Reason[:1]) + message.Reason[1:])
+				}
+				appManager.WriteEntryAndUpdate(fmt.Sprintf("You are in the matchmaking queue with rating %d, type \"unqueue\" to leave it", message.Rating))
+			case *MatchFoundMessage:
+				appManager.WriteEntryAndUpdate(fmt.Sprintf("Match found against %s (rating %d, yours is %d), type \"accept\" or \"decline\" within %s",
+					message.Opponent, message.OpponentRating, message.Rating, ConfirmTimeout))
+			case *RatingChangedMessage:
+				appManager.WriteEntryAndUpdate(fmt.Sprintf("Your rating is now %d (%+d)", message.Rating, message.Change))
+			case *NameRegisteredMessage:
+				appManager.SaveIdentity(message)
+			case *BracketMessage:
+				appManager.WriteEntryAndUpdate(message.Text)
+			case *RoomClosedMessage:
+				appManager.WriteEntryAndUpdate("The room was " + message.Reason)
+			case *ChatMessage:
+				appManager.ShowChat(message)
+			case *ErrorMessage:
+				appManager.WriteEntryAndUpdate("Error: " + message.Reason)
+			}
+		}
+	}
+}
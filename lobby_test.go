@@ -0,0 +1,786 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/gdamore/tcell"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//TestTimeout is how long a test client waits for a message, held writes of a reordering hub wait for the next heartbeat
+const TestTimeout = 3 * HeartbeatInterval
+
+//testLog structure records the log of a lobby
+type testLog struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+//Log function records a line
+func (log *testLog) Log(line string) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.lines = append(log.lines, line)
+}
+
+//Contains function reports whether a line was recorded
+func (log *testLog) Contains(line string) bool {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	for _, recorded := range log.lines {
+		if recorded == line {
+			return true
+		}
+	}
+	return false
+}
+
+//startTestLobby function serves a lobby on a MemoryHub with the given faults and returns its address
+func startTestLobby(t *testing.T, faults Faults) (*MemoryHub, *testLog, string) {
+	lobby, hub, log := serveTestLobby(t, faults)
+	return hub, log, lobby.Listener.Addr().String()
+}
+
+//serveTestLobby function serves a lobby on a MemoryHub with the given faults, it is shut down when the test ends
+func serveTestLobby(t *testing.T, faults Faults) (*Lobby, *MemoryHub, *testLog) {
+	hub := NewMemoryHub(1)
+	hub.Faults = faults
+	listener, err := hub.Listen("lobby:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby, log := serveTestLobbyOn(t, listener)
+	return lobby, hub, log
+}
+
+//serveTestLobbyOn function serves a lobby on listener, it is shut down when the test ends
+func serveTestLobbyOn(t *testing.T, listener net.Listener) (*Lobby, *testLog) {
+	log := &testLog{}
+	lobby := NewLobby(listener, log.Log)
+	lobby.Ratings.File = filepath.Join(t.TempDir(), RatingsFile)
+	lobby.Accounts.File = filepath.Join(t.TempDir(), AccountsFile)
+	go lobby.Serve()
+	t.Cleanup(lobby.Shutdown)
+	return lobby, log
+}
+
+//testPlayer structure is a client of the lobby driven by a test. Like PlayMatch it sends intents
+//and keeps its copy of the match with the events it receives, and like Reconnect it resumes its session when the connection drops.
+//events and snapshots keep everything the lobby sent about the match
+type testPlayer struct {
+	hub        *MemoryHub
+	address    string
+	connection *Connection
+	token      string
+	seat       Integer
+	match      *Match
+	pending    bool
+	events     []Message
+	snapshots  []*SnapshotMessage
+}
+
+//dialTestPlayer function connects a test client to the lobby
+func dialTestPlayer(hub *MemoryHub, address string) (*testPlayer, error) {
+	player := &testPlayer{hub: hub, address: address}
+	return player, player.dial()
+}
+
+//dial function opens a new connection to the lobby
+func (player *testPlayer) dial() error {
+	conn, err := player.hub.Dial(player.address)
+	if err != nil {
+		return err
+	}
+	player.connection = NewConnection(conn)
+	return player.connection.Handshake()
+}
+
+//receive function returns the next message that is not chat, an error if the connection drops or nothing arrives in time
+func (player *testPlayer) receive() (Message, error) {
+	timer := time.NewTimer(TestTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case message, ok := <-player.connection.Incoming:
+			if !ok {
+				return nil, player.connection.Err()
+			}
+			if _, ok := message.(*ChatMessage); ok {
+				continue
+			}
+			return message, nil
+		case <-timer.C:
+			return nil, errors.New("no message arrived in " + TestTimeout.String())
+		}
+	}
+}
+
+//expect function waits for a message of the given type, skipping the others
+func (player *testPlayer) expect(messageType string) (Message, error) {
+	for {
+		message, err := player.receive()
+		if err != nil {
+			return nil, err
+		}
+		if message.MessageType() == messageType {
+			return message, nil
+		}
+	}
+}
+
+//join function creates the room, or joins it if create is false, and waits for the match to start
+func (player *testPlayer) join(room string, create bool) error {
+	var request Message = &JoinRoomMessage{Name: room}
+	if create {
+		request = &CreateRoomMessage{Name: room}
+	}
+	player.connection.Send(request)
+	message, err := player.expect("joined-room")
+	if err != nil {
+		return err
+	}
+	player.token = message.(*JoinedRoomMessage).Token
+	return nil
+}
+
+//start function waits for the match to start
+func (player *testPlayer) start() error {
+	message, err := player.expect("match-start")
+	if err != nil {
+		return err
+	}
+	player.seat = message.(*MatchStartMessage).Seat
+	player.match = NewMatch(RoomSeats)
+	return nil
+}
+
+//resume function dials again until the lobby gives the seat back with a snapshot of the match
+func (player *testPlayer) resume() error {
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = player.dial(); err != nil {
+			continue
+		}
+		player.connection.Send(&ResumeMessage{Token: player.token})
+		var message Message
+		if message, err = player.receive(); err != nil {
+			continue
+		}
+		switch message := message.(type) {
+		case *SnapshotMessage:
+			player.snapshots = append(player.snapshots, message)
+			player.match = message.Match
+			player.pending = false
+			return nil
+		case *ErrorMessage:
+			return errors.New(message.Reason)
+		default:
+			return errors.New("the lobby answered the resume with " + message.MessageType())
+		}
+	}
+	return err
+}
+
+//testHand function returns the cheapest starting hand
+func testHand() []Integer {
+	indices := make([]Integer, len(ArregloDeCartas))
+	for index := range indices {
+		indices[index] = Integer(index)
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return ArregloDeCartas[indices[i]].Cost < ArregloDeCartas[indices[j]].Cost
+	})
+	return indices[:StartingHandSize]
+}
+
+//act function sends the next intent of the player unless the previous one is still unanswered:
+//its starting hand, then every card of its hand to the board and then ready
+func (player *testPlayer) act() {
+	if player.pending {
+		return
+	}
+	match := player.match
+	hand := match.Players[player.seat].Hand
+	var intent Message
+	switch {
+	case match.Phase == ChoosingHandsPhase && !match.HandChosen[player.seat]:
+		intent = &StartingHandMessage{Cards: testHand()}
+	case match.Phase == DeployingPhase && !match.Ready[player.seat] && len(hand) > 0:
+		deployed := StartingHandSize - Integer(len(hand))
+		intent = &DeployMessage{HandIndex: 0, Row: deployed / BoardColumns, Column: deployed % BoardColumns}
+	case match.Phase == DeployingPhase && !match.Ready[player.seat]:
+		intent = &ReadyMessage{}
+	}
+	if intent != nil {
+		player.connection.Send(intent)
+		player.pending = true
+	}
+}
+
+//play function plays the match until it finishes, resuming the session whenever the connection drops.
+//deploying is called once the match reaches DeployingPhase
+func (player *testPlayer) play(deploying func()) error {
+	called := false
+	for player.match.Phase != FinishedPhase {
+		if player.match.Phase == DeployingPhase && deploying != nil && !called {
+			called = true
+			deploying()
+		}
+		player.act()
+		message, err := player.receive()
+		if err != nil {
+			if err = player.resume(); err != nil {
+				return err
+			}
+			continue
+		}
+		switch message := message.(type) {
+		case *HandChosenMessage, *CardDeployedMessage, *PlayerReadyMessage, *PhaseMessage:
+			player.events = append(player.events, message)
+			player.match.ApplyEvent(message)
+			if eventSeat(message) == player.seat {
+				player.pending = false
+			}
+		case *ErrorMessage:
+			return errors.New(message.Reason)
+		case *PlayerLeftMessage:
+			return errors.New("the opponent left the match")
+		}
+	}
+	return nil
+}
+
+//eventSeat function returns the seat of an event, -1 for a PhaseMessage
+func eventSeat(event Message) Integer {
+	switch event := event.(type) {
+	case *HandChosenMessage:
+		return event.Seat
+	case *CardDeployedMessage:
+		return event.Seat
+	case *PlayerReadyMessage:
+		return event.Seat
+	}
+	return -1
+}
+
+//startTestMatch function connects two test clients to the lobby and seats them in a new room
+func startTestMatch(t *testing.T, hub *MemoryHub, address string) [2]*testPlayer {
+	var players [2]*testPlayer
+	for index := range players {
+		player, err := dialTestPlayer(hub, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := player.join("duel", index == 0); err != nil {
+			t.Fatal(err)
+		}
+		players[index] = player
+	}
+	for _, player := range players {
+		if err := player.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return players
+}
+
+//playTestMatch function plays the match of both clients at the same time until the lobby finishes it
+func playTestMatch(t *testing.T, log *testLog, players [2]*testPlayer, deploying func()) {
+	errs := make(chan error, len(players))
+	for _, player := range players {
+		go func(player *testPlayer) {
+			errs <- player.play(deploying)
+		}(player)
+	}
+	for range players {
+		if err := <-errs; err != nil {
+			//A seat that lost the last events can not resume a room that already finished
+			if err.Error() != "the session expired" {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !log.Contains("Match finished in room duel") {
+		t.Fatal("the lobby did not finish the match")
+	}
+	for _, player := range players {
+		if player.match.Phase != FinishedPhase {
+			continue
+		}
+		for seat, opponent := range player.match.Players {
+			if opponent.Board[0][StartingHandSize-1].Name == "" {
+				t.Fatalf("seat %d sees the board of seat %d without its cards", player.seat, seat)
+			}
+		}
+	}
+}
+
+//TestLobbyMatch plays a whole match between two clients through the lobby
+func TestLobbyMatch(t *testing.T) {
+	hub, log, address := startTestLobby(t, Faults{})
+	players := startTestMatch(t, hub, address)
+	playTestMatch(t, log, players, nil)
+	for _, player := range players {
+		if player.match.Phase != FinishedPhase {
+			t.Fatalf("seat %d did not see the end of the match", player.seat)
+		}
+	}
+}
+
+//TestLobbyMatchWithLatency plays a match on a slow network
+func TestLobbyMatchWithLatency(t *testing.T) {
+	hub, log, address := startTestLobby(t, Faults{Latency: 20 * time.Millisecond, Jitter: 30 * time.Millisecond})
+	playTestMatch(t, log, startTestMatch(t, hub, address), nil)
+}
+
+//TestLobbyMatchWithReorder plays a match on a network that starts reordering messages once the match started,
+//the connections notice the wrong sequence and the clients resume their sessions until they finish it.
+//Before the match there is no session to resume, so the hub reorders only the connections dialed after the cut
+func TestLobbyMatchWithReorder(t *testing.T) {
+	hub, log, address := startTestLobby(t, Faults{})
+	players := startTestMatch(t, hub, address)
+	hub.Faults = Faults{Latency: time.Millisecond, Reorder: 0.05}
+	hub.Cut()
+	playTestMatch(t, log, players, nil)
+}
+
+//TestLobbyMatchWithCut cuts the network in the middle of the match, both clients resume their seats and finish it
+func TestLobbyMatchWithCut(t *testing.T) {
+	hub, log, address := startTestLobby(t, Faults{})
+	players := startTestMatch(t, hub, address)
+	var cut sync.Once
+	playTestMatch(t, log, players, func() {
+		cut.Do(hub.Cut)
+	})
+	for _, player := range players {
+		if player.match.Phase != FinishedPhase {
+			t.Fatalf("seat %d did not see the end of the match", player.seat)
+		}
+	}
+	if !log.Contains("A player reconnected to room duel") {
+		t.Fatal("no player resumed its session")
+	}
+	if log.Contains("A player did not reconnect in time to room duel") {
+		t.Fatal("a seat was forfeited")
+	}
+}
+
+//TestLobbyRedactsOpponentCards checks that no client receives the cards in the hand of its opponent,
+//neither in the events of the match nor in the snapshot of a resumed session
+func TestLobbyRedactsOpponentCards(t *testing.T) {
+	hub, log, address := startTestLobby(t, Faults{})
+	players := startTestMatch(t, hub, address)
+	var cut sync.Once
+	playTestMatch(t, log, players, func() {
+		cut.Do(hub.Cut)
+	})
+	for _, player := range players {
+		for _, event := range player.events {
+			switch event := event.(type) {
+			case *HandChosenMessage:
+				if event.Seat == player.seat && event.Cards == nil {
+					t.Fatalf("seat %d did not receive its own starting hand", player.seat)
+				}
+				if event.Seat != player.seat && (event.Cards != nil || event.Count != StartingHandSize) {
+					t.Fatalf("seat %d received the starting hand of seat %d: %v", player.seat, event.Seat, event.Cards)
+				}
+			case *CardDeployedMessage:
+				if event.Seat != player.seat && event.HandIndex != -1 {
+					t.Fatalf("seat %d received the hand index %d of a card of seat %d", player.seat, event.HandIndex, event.Seat)
+				}
+			}
+		}
+		if len(player.snapshots) == 0 {
+			t.Fatalf("seat %d did not resume its session", player.seat)
+		}
+		for _, snapshot := range player.snapshots {
+			for seat, seen := range snapshot.Match.Players {
+				if seen.Deck != nil {
+					t.Fatalf("seat %d received the deck of seat %d", player.seat, seat)
+				}
+				deployed := 0
+				for _, row := range seen.Board {
+					for _, card := range row {
+						if card.Name != "" {
+							deployed++
+						}
+					}
+				}
+				if Integer(seat) == player.seat && Integer(len(seen.Hand)+deployed) != StartingHandSize {
+					t.Fatalf("seat %d did not receive its own hand", player.seat)
+				}
+				if Integer(seat) != player.seat && (seen.Hand != nil || seen.HandCount+Integer(deployed) != StartingHandSize) {
+					t.Fatalf("seat %d received the hand of seat %d: %v", player.seat, seat, seen.Hand)
+				}
+			}
+		}
+	}
+}
+
+//waitForEntry function waits until the app manager shows an entry containing text
+func waitForEntry(t *testing.T, appManager *AppManager, text string) {
+	deadline := time.Now().Add(TestTimeout)
+	for time.Now().Before(deadline) {
+		appManager.ScreenMutex.Lock()
+		lines := appManager.UI.BufferWidget.Lines
+		appManager.ScreenMutex.Unlock()
+		for _, line := range lines {
+			if strings.Contains(string(line), text) {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the app manager did not show %q", text)
+}
+
+//startTestAppManagerMatch function starts a match in room duel between an app manager, whose commands the test types,
+//and a test client
+func startTestAppManagerMatch(t *testing.T) (*AppManager, *testPlayer, *testLog) {
+	directory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(directory)
+	})
+	hub, log, address := startTestLobby(t, Faults{Latency: time.Millisecond})
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	screen.SetSize(120, 40)
+	appManager := NewAppManagerWithScreen(screen)
+	appManager.Transport = hub
+	appManager.ServerAddress = address
+	appManager.PlayerName = "Ana"
+	appManager.DialServer()
+	if appManager.Connection == nil {
+		t.Fatal("the app manager could not connect to the lobby")
+	}
+	go appManager.PlayLobby()
+	appManager.CommandChannel <- []rune("create duel")
+	waitForEntry(t, appManager, "You are in room duel")
+	opponent, err := dialTestPlayer(hub, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opponent.join("duel", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := opponent.start(); err != nil {
+		t.Fatal(err)
+	}
+	return appManager, opponent, log
+}
+
+//TestAppManagerPlaysLobbyMatch plays a match through the lobby typing the commands of a player in the app manager,
+//against a test client
+func TestAppManagerPlaysLobbyMatch(t *testing.T) {
+	appManager, opponent, log := startTestAppManagerMatch(t)
+	deploying := make(chan struct{})
+	played := make(chan error, 1)
+	go func() {
+		played <- opponent.play(func() {
+			close(deploying)
+		})
+	}()
+	var hand []string
+	for _, card := range testHand() {
+		hand = append(hand, strconv.Itoa(int(card)+1))
+	}
+	waitForEntry(t, appManager, "Escribe los numeros o los nombres de las cartas")
+	appManager.CommandChannel <- []rune(strings.Join(hand, " "))
+	waitForEntry(t, appManager, "A) Confirmar mano")
+	appManager.CommandChannel <- []rune("a")
+	select {
+	case <-deploying:
+	case err := <-played:
+		t.Fatal(err)
+	case <-time.After(TestTimeout):
+		t.Fatal("the match did not reach the deploy phase")
+	}
+	appManager.CommandChannel <- []rune("deploy 1 1 1")
+	appManager.CommandChannel <- []rune("ready")
+	if err := <-played; err != nil {
+		t.Fatal(err)
+	}
+	waitForEntry(t, appManager, "Back in the lobby")
+	if !log.Contains("Match finished in room duel") {
+		t.Fatal("the lobby did not finish the match")
+	}
+	if card := opponent.match.Players[1-opponent.seat].Board[0][0]; card.Name != ArregloDeCartas[testHand()[0]].Name {
+		t.Fatalf("the opponent sees %q in the first cell instead of the card the player deployed", card.Name)
+	}
+}
+
+//TestAppManagerLeavesWhileChoosingItsHand checks that the chat, the events of the opponent and leave
+//work while the player of the app manager chooses its starting hand
+func TestAppManagerLeavesWhileChoosingItsHand(t *testing.T) {
+	appManager, opponent, _ := startTestAppManagerMatch(t)
+	waitForEntry(t, appManager, "Escribe los numeros o los nombres de las cartas")
+	opponent.connection.Send(&StartingHandMessage{Cards: testHand()})
+	waitForEntry(t, appManager, "Your opponent chose a starting hand of 4 cards")
+	appManager.CommandChannel <- []rune("/say hola")
+	waitForEntry(t, appManager, "Ana: hola")
+	appManager.CommandChannel <- []rune("leave")
+	waitForEntry(t, appManager, "You forfeited the match")
+	message, err := opponent.expect("player-left")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.(*PlayerLeftMessage).Seat == opponent.seat {
+		t.Fatal("the lobby says the test client left")
+	}
+}
+
+//TestLobbyAnswersIntentsBeforeTheMatch checks that the intents of a client waiting in its room are answered
+//with an error instead of waiting for a match that did not start, and that the client can still use the lobby
+func TestLobbyAnswersIntentsBeforeTheMatch(t *testing.T) {
+	hub, _, address := startTestLobby(t, Faults{})
+	player, err := dialTestPlayer(hub, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := player.join("duel", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, intent := range []Message{&ReadyMessage{}, &DeployMessage{}, &StartingHandMessage{Cards: testHand()}, &ResumeMessage{Token: player.token}} {
+		player.connection.Send(intent)
+		message, err := player.expect("error")
+		if err != nil {
+			t.Fatalf("%s was not answered: %v", intent.MessageType(), err)
+		}
+		if reason := message.(*ErrorMessage).Reason; reason != "the match has not started" {
+			t.Fatalf("%s was answered with %q", intent.MessageType(), reason)
+		}
+	}
+	player.connection.Send(&ListRoomsMessage{})
+	message, err := player.expect("rooms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rooms := message.(*RoomsMessage).Rooms; len(rooms) != 1 || rooms[0].Name != "duel" {
+		t.Fatalf("the lobby lists %v", rooms)
+	}
+}
+
+//setName function sends a name to the lobby and returns the secret it issued, if it registered the name,
+//or the error the lobby answered
+func (player *testPlayer) setName(name, secret string, register bool) (string, error) {
+	player.connection.Send(&SetNameMessage{Name: name, Secret: secret, Register: register})
+	player.connection.Send(&ListRoomsMessage{})
+	issued := ""
+	var answer error
+	for {
+		message, err := player.receive()
+		if err != nil {
+			return "", err
+		}
+		switch message := message.(type) {
+		case *NameRegisteredMessage:
+			issued = message.Secret
+		case *ErrorMessage:
+			answer = errors.New(message.Reason)
+		case *RoomsMessage:
+			return issued, answer
+		}
+	}
+}
+
+//TestLobbyNames checks that only a registered name needs its secret, that only an explicit request registers a name
+//and that two clients never use the same name, registered or not
+func TestLobbyNames(t *testing.T) {
+	hub, _, address := startTestLobby(t, Faults{})
+	var players [3]*testPlayer
+	for index := range players {
+		player, err := dialTestPlayer(hub, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		players[index] = player
+	}
+	expect := func(player *testPlayer, name, secret string, register bool, expected string) string {
+		t.Helper()
+		issued, err := player.setName(name, secret, register)
+		if err != nil && err.Error() != expected {
+			t.Fatalf("setting the name %s was answered with %q instead of %q", name, err.Error(), expected)
+		}
+		if err == nil && expected != "" {
+			t.Fatalf("setting the name %s was accepted instead of answered with %q", name, expected)
+		}
+		return issued
+	}
+	inUse := "the name Ana is being used by another player"
+	if issued := expect(players[0], "Ana", "", false, ""); issued != "" {
+		t.Fatal("the name was registered without asking")
+	}
+	expect(players[1], "Ana", "", false, inUse)
+	expect(players[0], "Bea", "", false, "")
+	expect(players[1], "Ana", "", false, "")
+	secret := expect(players[1], "Ana", "", true, "")
+	if secret == "" {
+		t.Fatal("the registered name has no secret")
+	}
+	expect(players[1], "Ana", secret, true, "the name Ana is already yours")
+	expect(players[2], "Ana", secret, false, inUse)
+	expect(players[1], "Eva", "", false, "")
+	expect(players[2], "Ana", "", false, "the name Ana belongs to another player, only the client that registered it can use it")
+	expect(players[2], "Ana", secret, false, "")
+	expect(players[0], "Ana", secret, false, inUse)
+}
+
+//TestLobbyShutdownClosesRooms checks that Shutdown closes a running match, with a seat waiting for its player to reconnect,
+//and tells the players before closing their connections
+func TestLobbyShutdownClosesRooms(t *testing.T) {
+	lobby, hub, log := serveTestLobby(t, Faults{})
+	players := startTestMatch(t, hub, lobby.Listener.Addr().String())
+	players[0].connection.Close()
+	if _, err := players[1].expect("player-disconnected"); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	lobby.Shutdown()
+	if elapsed := time.Since(started); elapsed >= ShutdownTimeout {
+		t.Fatalf("the shutdown took %s", elapsed)
+	}
+	message, err := players[1].expect("room-closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := message.(*RoomClosedMessage).Reason; reason != "closed because the server is shutting down" {
+		t.Fatalf("the room was %s", reason)
+	}
+	if !log.Contains("Room duel closed because the server is shutting down") || log.Contains("Match finished in room duel") {
+		t.Fatal("the match was not aborted")
+	}
+	if len(lobby.RoomInfos()) != 0 {
+		t.Fatal("the lobby still lists rooms")
+	}
+}
+
+//TestLobbyRoomPasswords checks that a locked room only seats the players that give its password
+func TestLobbyRoomPasswords(t *testing.T) {
+	hub, log, address := startTestLobby(t, Faults{})
+	var players [2]*testPlayer
+	for index := range players {
+		player, err := dialTestPlayer(hub, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		players[index] = player
+	}
+	players[0].connection.Send(&CreateRoomMessage{Name: "vault", Password: "secret"})
+	if _, err := players[0].expect("joined-room"); err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"", "Secret", "secret "} {
+		players[1].connection.Send(&JoinRoomMessage{Name: "vault", Password: password})
+		message, err := players[1].expect("error")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason := message.(*ErrorMessage).Reason; reason != "wrong password for room vault" {
+			t.Fatalf("the password %q was answered with %q", password, reason)
+		}
+	}
+	if !log.Contains("Wrong password for room vault") {
+		t.Fatal("the lobby did not log the wrong passwords")
+	}
+	players[1].connection.Send(&JoinRoomMessage{Name: "vault", Password: "secret"})
+	if _, err := players[1].expect("joined-room"); err != nil {
+		t.Fatal(err)
+	}
+	for _, player := range players {
+		if err := player.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//serveTLSTestLobby function serves a lobby with a new certificate over TLS at address of hub and returns
+//the fingerprint of its certificate
+func serveTLSTestLobby(t *testing.T, hub *MemoryHub, address string) (*Lobby, string) {
+	directory := t.TempDir()
+	certificate, err := LoadOrCreateCertificate(filepath.Join(directory, "server.crt"), filepath.Join(directory, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := hub.Listen(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby, _ := serveTestLobbyOn(t, tls.NewListener(listener, ServerTLSConfig(certificate)))
+	return lobby, CertificateFingerprint(certificate.Certificate[0])
+}
+
+//dialTLSTestLobby function connects to the lobby at address of hub with the pinning of ClientTLSConfig
+//and returns the fingerprint it trusted for the first time, if any
+func dialTLSTestLobby(hub *MemoryHub, address string) (string, error) {
+	conn, err := hub.Dial(address)
+	if err != nil {
+		return "", err
+	}
+	trusted := ""
+	client := tls.Client(conn, ClientTLSConfig(address, func(fingerprint string) {
+		trusted = fingerprint
+	}))
+	if err := client.Handshake(); err != nil {
+		client.Close()
+		return "", err
+	}
+	connection := NewConnection(client)
+	defer connection.Close()
+	return trusted, connection.Handshake()
+}
+
+//TestTLSPinning checks that the client trusts the certificate of a new server, keeps trusting it
+//and rejects the server once its certificate changed
+func TestTLSPinning(t *testing.T) {
+	defer func(file string) {
+		KnownServersFile = file
+	}(KnownServersFile)
+	KnownServersFile = filepath.Join(t.TempDir(), "known_servers.json")
+	hub := NewMemoryHub(1)
+	address := "secure:1"
+	lobby, fingerprint := serveTLSTestLobby(t, hub, address)
+	trusted, err := dialTLSTestLobby(hub, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted != fingerprint {
+		t.Fatalf("the client trusted %q instead of %q", trusted, fingerprint)
+	}
+	if known := LoadKnownServers()[address]; known != fingerprint {
+		t.Fatalf("the client stored %q instead of %q", known, fingerprint)
+	}
+	trusted, err = dialTLSTestLobby(hub, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trusted != "" {
+		t.Fatal("the client trusted a known server again")
+	}
+	lobby.Shutdown()
+	_, changed := serveTLSTestLobby(t, hub, address)
+	if changed == fingerprint {
+		t.Fatal("the new certificate has the fingerprint of the old one")
+	}
+	if _, err := dialTLSTestLobby(hub, address); err == nil || !strings.Contains(err.Error(), "the certificate of "+address+" changed") {
+		t.Fatalf("the client connected to a server whose certificate changed: %v", err)
+	}
+	if known := LoadKnownServers()[address]; known != fingerprint {
+		t.Fatalf("the client replaced the fingerprint it stored with %q", known)
+	}
+}
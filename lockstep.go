@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//LockstepStartMessage structure is sent by the host of a lockstep match with the seed both peers use
+type LockstepStartMessage struct {
+	Seed int64
+}
+
+//MessageType function
+func (message *LockstepStartMessage) MessageType() string {
+	return "lockstep-start"
+}
+
+//LockstepCommandMessage structure carries the intent of a peer for a turn, a nil Intent passes the turn
+type LockstepCommandMessage struct {
+	Turn   Integer
+	Intent *Envelope
+}
+
+//MessageType function
+func (message *LockstepCommandMessage) MessageType() string {
+	return "lockstep-command"
+}
+
+//LockstepHashMessage structure carries the hash of the match of a peer after a turn
+type LockstepHashMessage struct {
+	Turn Integer
+	Hash string
+}
+
+//MessageType function
+func (message *LockstepHashMessage) MessageType() string {
+	return "lockstep-hash"
+}
+
+//LockstepStateMessage structure carries the whole match of a peer after a turn whose hashes did not match
+type LockstepStateMessage struct {
+	Turn  Integer
+	Match *Match
+}
+
+//MessageType function
+func (message *LockstepStateMessage) MessageType() string {
+	return "lockstep-state"
+}
+
+//Hash function returns the SHA-256 of the match encoded as JSON, equal matches have equal hashes
+func (match *Match) Hash() string {
+	bytes, _ := json.Marshal(match)
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+//HasTurn function reports whether seat has to send an intent, otherwise it passes the turn
+func (match *Match) HasTurn(seat Integer) bool {
+	switch match.Phase {
+	case ChoosingHandsPhase:
+		return !match.HandChosen[seat]
+	case DeployingPhase:
+		return !match.Ready[seat]
+	}
+	return false
+}
+
+//DiffFields function lists the fields that differ between two values, as paths like Players[1].Credit
+func DiffFields(a, b interface{}) []string {
+	var genericA, genericB interface{}
+	bytesA, _ := json.Marshal(a)
+	bytesB, _ := json.Marshal(b)
+	json.Unmarshal(bytesA, &genericA)
+	json.Unmarshal(bytesB, &genericB)
+	var diffs []string
+	diffValues("", genericA, genericB, &diffs)
+	return diffs
+}
+
+//diffValues function appends to diffs the paths below path where a and b, decoded from JSON, differ
+func diffValues(path string, a, b interface{}, diffs *[]string) {
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if okA && okB {
+		var keys []string
+		for key := range objectA {
+			keys = append(keys, key)
+		}
+		for key := range objectB {
+			if _, ok := objectA[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			diffValues(field, objectA[key], objectB[key], diffs)
+		}
+		return
+	}
+	arrayA, okA := a.([]interface{})
+	arrayB, okB := b.([]interface{})
+	if okA && okB && len(arrayA) == len(arrayB) {
+		for index := range arrayA {
+			diffValues(path+"["+strconv.Itoa(index)+"]", arrayA[index], arrayB[index], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, path+": "+StructToJSON(a)+" != "+StructToJSON(b))
+	}
+}
+
+//DumpDesync function writes both states of a desynchronized turn to disk and returns the file names
+func DumpDesync(turn Integer, states map[Integer]*Match) ([]string, error) {
+	var files []string
+	for seat := Integer(0); seat < Integer(len(states)); seat++ {
+		file := "desync-turn-" + strconv.Itoa(int(turn)) + "-seat-" + strconv.Itoa(int(seat)) + ".json"
+		if err := ioutil.WriteFile(file, []byte(StructToJSONPretty(states[seat])), 0644); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+//PlayPeer function hosts or joins a peer to peer match in deterministic lockstep, without a server
+func (appManager *AppManager) PlayPeer() {
+	appManager.WriteEntryAndUpdate("Do you want to host the match or join one (host/join)?")
+	for {
+		command := string(appManager.ReadCommand())
+		if strings.EqualFold(command, "host") {
+			appManager.HostPeer()
+			return
+		} else if strings.EqualFold(command, "join") {
+			appManager.JoinPeer()
+			return
+		}
+		appManager.WriteEntryAndUpdate("Incorrect answer(" + command + "), choose between: host/join, and try again")
+	}
+}
+
+//HostPeer function waits for one peer on the chosen address and starts the match as seat 0
+func (appManager *AppManager) HostPeer() {
+	appManager.FindLocalIPs()
+	appManager.AskForIP()
+	listener := appManager.Listen()
+	if listener == nil {
+		return
+	}
+	appManager.WriteEntryAndUpdate("Waiting for your opponent at tcp://" + listener.Addr().String() + ", type \"cancel\" to stop waiting")
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	var conn net.Conn
+	for conn == nil {
+		select {
+		case command := <-appManager.CommandChannel:
+			if strings.EqualFold(string(command), "cancel") {
+				listener.Close()
+				if late, ok := <-accepted; ok {
+					late.Close()
+				}
+				appManager.WriteEntryAndUpdate("You stopped waiting for an opponent")
+				return
+			}
+		case conn = <-accepted:
+			listener.Close()
+			if conn == nil {
+				appManager.WriteEntryAndUpdate("Server error: the listener stopped")
+				return
+			}
+		}
+	}
+	appManager.SetConnection(NewConnection(conn))
+	if err := appManager.Connection.Handshake(); err != nil {
+		appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", err))
+		appManager.Connection.Reject(err.Error())
+		return
+	}
+	if !appManager.Connection.HasFeature(LockstepFeature) {
+		appManager.Connection.Reject("this peer only plays lockstep matches")
+		appManager.WriteEntryAndUpdate("Your opponent does not support lockstep matches")
+		return
+	}
+	seed := time.Now().UnixNano()
+	appManager.SendMessage(&LockstepStartMessage{Seed: seed})
+	appManager.PlayLockstep(0, seed)
+}
+
+//JoinPeer function connects to the host of a lockstep match and plays it as seat 1
+func (appManager *AppManager) JoinPeer() {
+	appManager.ConnectToServer()
+	if appManager.Connection == nil {
+		return
+	}
+	if !appManager.Connection.HasFeature(LockstepFeature) {
+		appManager.WriteEntryAndUpdate("The host does not support lockstep matches")
+		appManager.Connection.Close()
+		return
+	}
+	message, ok := <-appManager.Connection.Incoming
+	start, isStart := message.(*LockstepStartMessage)
+	if !ok || !isStart {
+		appManager.WriteEntryAndUpdate("The host did not start a lockstep match")
+		appManager.Connection.Close()
+		return
+	}
+	appManager.PlayLockstep(1, start.Seed)
+}
+
+//PlayLockstep function plays a match in which both peers run the engine. Every turn each peer sends only its intent,
+//both apply the intents in seat order and exchange the hash of the resulting match.
+//If the hashes differ both matches are written to disk and the diverging fields are reported
+func (appManager *AppManager) PlayLockstep(seat Integer, seed int64) {
+	defer appManager.Connection.Close()
+	appManager.Match = NewMatch(RoomSeats)
+	appManager.Match.Seed = seed
+	appManager.Seat = seat
+	appManager.Player = appManager.Match.Players[seat]
+	appManager.WriteEntryAndUpdate("The lockstep match started, type \"leave\" at any moment to forfeit it")
+	var turn Integer
+	var local *LockstepCommandMessage
+	remote := make(map[Integer]*LockstepCommandMessage)
+	hashes := make(map[Integer]string)
+	remoteHashes := make(map[Integer]string)
+	states := make(map[Integer]*Match)
+	for {
+		for checked := range hashes {
+			remoteHash, ok := remoteHashes[checked]
+			if !ok {
+				continue
+			}
+			if remoteHash != hashes[checked] {
+				appManager.ReportDesync(checked, states[checked])
+				return
+			}
+			delete(hashes, checked)
+			delete(remoteHashes, checked)
+			delete(states, checked)
+		}
+		if appManager.Match.Phase == FinishedPhase {
+			if len(hashes) == 0 {
+				appManager.WriteEntryAndUpdate(BoardString(appManager.Player))
+				return
+			}
+		} else if local == nil && !appManager.Match.HasTurn(seat) {
+			local = &LockstepCommandMessage{Turn: turn}
+			appManager.SendMessage(local)
+		} else if local == nil && appManager.Match.Phase == ChoosingHandsPhase {
+			local = &LockstepCommandMessage{Turn: turn}
+			local.Intent, _ = EncodeMessage(turn, &StartingHandMessage{Cards: appManager.ChooseStartingHand(appManager.Player.Credit)})
+			appManager.SendMessage(local)
+		} else if local != nil && remote[turn] != nil {
+			commands := map[Integer]*LockstepCommandMessage{seat: local, 1 - seat: remote[turn]}
+			for commandSeat := Integer(0); commandSeat < RoomSeats; commandSeat++ {
+				if commands[commandSeat].Intent == nil {
+					continue
+				}
+				intent, err := DecodeMessage(commands[commandSeat].Intent)
+				if err != nil {
+					appManager.WriteEntryAndUpdate(fmt.Sprint("Invalid command from your opponent: ", err))
+					return
+				}
+				events, err := appManager.Match.Apply(commandSeat, intent)
+				if err != nil && commandSeat == seat {
+					appManager.WriteEntryAndUpdate("Rejected: " + err.Error())
+				}
+				for _, event := range events {
+					appManager.WriteEntryAndUpdate(DescribeEvent(event, seat))
+				}
+			}
+			hashes[turn] = appManager.Match.Hash()
+			states[turn] = appManager.Match.Clone()
+			appManager.SendMessage(&LockstepHashMessage{Turn: turn, Hash: hashes[turn]})
+			delete(remote, turn)
+			local = nil
+			turn++
+			continue
+		}
+		select {
+		case command := <-appManager.CommandChannel:
+			if strings.EqualFold(string(command), "board") {
+				appManager.WriteEntryAndUpdate(BoardString(appManager.Player) + "\nHand:\n" + HandString(appManager.Player))
+				continue
+			}
+			if strings.EqualFold(string(command), "leave") {
+				appManager.WriteEntryAndUpdate("You forfeited the match")
+				return
+			}
+			if local != nil {
+				appManager.WriteEntryAndUpdate("Waiting for your opponent to finish turn " + strconv.Itoa(int(turn+1)))
+				continue
+			}
+			intent, err := ParseMatchCommand(string(command))
+			if err != nil {
+				appManager.WriteEntryAndUpdate(err.Error())
+				continue
+			}
+			local = &LockstepCommandMessage{Turn: turn}
+			local.Intent, _ = EncodeMessage(turn, intent)
+			appManager.SendMessage(local)
+		case message, ok := <-appManager.Connection.Incoming:
+			if !ok {
+				appManager.WriteEntryAndUpdate(fmt.Sprint("Your opponent left the match: ", appManager.Connection.Err()))
+				return
+			}
+			switch message := message.(type) {
+			case *LockstepCommandMessage:
+				remote[message.Turn] = message
+			case *LockstepHashMessage:
+				remoteHashes[message.Turn] = message.Hash
+			}
+		}
+	}
+}
+
+//ReportDesync function exchanges the matches of a turn whose hashes differ, dumps both to disk and lists the diverging fields
+func (appManager *AppManager) ReportDesync(turn Integer, state *Match) {
+	appManager.WriteEntryAndUpdate("Desync detected after turn " + strconv.Itoa(int(turn+1)) + ", exchanging states")
+	appManager.SendMessage(&LockstepStateMessage{Turn: turn, Match: state})
+	states := map[Integer]*Match{appManager.Seat: state}
+	for message := range appManager.Connection.Incoming {
+		if remote, ok := message.(*LockstepStateMessage); ok && remote.Turn == turn {
+			states[1-appManager.Seat] = remote.Match
+			break
+		}
+	}
+	if len(states) < int(RoomSeats) {
+		appManager.WriteEntryAndUpdate("Your opponent left before sending its state")
+		return
+	}
+	files, err := DumpDesync(turn, states)
+	if err != nil {
+		appManager.WriteEntryAndUpdate(fmt.Sprint("Could not write the states: ", err))
+	} else {
+		appManager.WriteEntryAndUpdate("States written to " + strings.Join(files, " and "))
+	}
+	appManager.WriteEntryAndUpdate("Diverging fields (seat 1 != seat 2):\n" + strings.Join(DiffFields(states[0], states[1]), "\n"))
+}
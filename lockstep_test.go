@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//TestDiffFields checks that two diverging copies of a match have different hashes and that DiffFields names every field
+//that diverged, and only those
+func TestDiffFields(t *testing.T) {
+	match := NewMatch(RoomSeats)
+	clone := match.Clone()
+	if match.Hash() != clone.Hash() {
+		t.Fatal("a clone of a match has another hash")
+	}
+	if diffs := DiffFields(match, clone); len(diffs) != 0 {
+		t.Fatalf("a clone of a match differs in %v", diffs)
+	}
+	clone.Players[1].Credit--
+	clone.HandChosen[0] = true
+	if match.Hash() == clone.Hash() {
+		t.Fatal("two diverging matches have the same hash")
+	}
+	expected := []string{
+		"HandChosen[0]: false != true",
+		"Players[1].Credit: 4 != 3",
+	}
+	if diffs := DiffFields(match, clone); !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("the diverging fields are %q instead of %q", diffs, expected)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gdamore/tcell"
 	"net"
@@ -17,6 +18,7 @@ import (
 const (
 	ServerApplication Integer = iota
 	ClientApplication
+	PeerApplication
 )
 
 type Server struct {
@@ -321,15 +323,127 @@ var ArregloDeCartas []*Card = []*Card{
 	NewPriestCard(),
 	NewWarlockCard(),
 }
-//Player structure
+
+//Tamaño de la mano inicial y credito con el que empieza cada jugador
+const (
+	StartingHandSize Integer = 4
+	StartingCredit   Integer = 4
+)
+
+//FindCardIndexByName function returns the index in ArregloDeCartas of the card with the given name or -1
+func FindCardIndexByName(name string) Integer {
+	for index, card := range ArregloDeCartas {
+		if strings.EqualFold(card.Name, name) {
+			return Integer(index)
+		}
+	}
+	return -1
+}
+
+//ParseCardSelection function turns input such as "1 3 5 7" or "mago elfo mago" into indices of ArregloDeCartas.
+//Numbers start at 1 and names may contain spaces, the longest matching name wins
+func ParseCardSelection(input string) ([]Integer, error) {
+	tokens := strings.FieldsFunc(input, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	var indices []Integer
+	for start := 0; start < len(tokens); {
+		number, err := strconv.Atoi(tokens[start])
+		if err == nil {
+			if number <= 0 || number > len(ArregloDeCartas) {
+				return nil, fmt.Errorf("El numero %d no existe, escoje entre 1 y %d", number, len(ArregloDeCartas))
+			}
+			indices = append(indices, Integer(number-1))
+			start++
+			continue
+		}
+		end := len(tokens)
+		for ; end > start; end-- {
+			index := FindCardIndexByName(strings.Join(tokens[start:end], " "))
+			if index >= 0 {
+				indices = append(indices, index)
+				break
+			}
+		}
+		if end == start {
+			return nil, fmt.Errorf("No existe ninguna carta llamada %s", tokens[start])
+		}
+		start = end
+	}
+	return indices, nil
+}
+
+//ValidateStartingHand function checks the size, duplicates and total cost of a starting hand
+func ValidateStartingHand(indices []Integer, credit Integer) error {
+	return ValidateHand(indices, StartingHandSize, credit)
+}
+
+//ValidateHand function checks that a hand has size cards, without duplicates, that cost at most credit
+func ValidateHand(indices []Integer, size, credit Integer) error {
+	if Integer(len(indices)) != size {
+		return fmt.Errorf("Debes escoger %d cartas, escogiste %d", size, len(indices))
+	}
+	chosen := make(map[Integer]bool)
+	var cost Integer
+	for _, index := range indices {
+		if index < 0 || index >= Integer(len(ArregloDeCartas)) {
+			return fmt.Errorf("La carta %d no existe", index+1)
+		}
+		if chosen[index] {
+			return errors.New("La carta " + ArregloDeCartas[index].Name + " esta repetida")
+		}
+		chosen[index] = true
+		cost += ArregloDeCartas[index].Cost
+	}
+	if cost > credit {
+		return fmt.Errorf("Las cartas cuestan %d y solo tienes %d de credito", cost, credit)
+	}
+	return nil
+}
+
+//HandCost function adds up the cost of the chosen cards
+func HandCost(indices []Integer) Integer {
+	var cost Integer
+	for _, index := range indices {
+		cost += ArregloDeCartas[index].Cost
+	}
+	return cost
+}
+
+//NewStartingHand function copies the chosen cards out of ArregloDeCartas
+func NewStartingHand(indices []Integer) []Card {
+	hand := make([]Card, 0, len(indices))
+	for _, index := range indices {
+		hand = append(hand, *ArregloDeCartas[index])
+	}
+	return hand
+}
+//Player structure. HandCount and DeckCount are only set in the views of a match sent to clients, where Hand and Deck are hidden
 type Player struct {
-	Health   Integer
-	RedArmor Integer
-	Energy   Integer
-	Credit   Integer
-	Deck     []Card
-	Hand     []Card
-	Board    [][]Card
+	Health    Integer
+	RedArmor  Integer
+	Energy    Integer
+	Credit    Integer
+	Deck      []Card
+	Hand      []Card
+	Board     [][]Card
+	HandCount Integer
+	DeckCount Integer
+}
+
+//Clone function returns a copy of the player that shares no slices with the original,
+//so simulations can modify it freely. StatisticsPerLevel is shared because it never changes
+func (player *Player) Clone() *Player {
+	clone := *player
+	clone.Deck = append([]Card(nil), player.Deck...)
+	clone.Hand = append([]Card(nil), player.Hand...)
+	if player.Board != nil {
+		clone.Board = make([][]Card, len(player.Board))
+		for index, row := range player.Board {
+			clone.Board[index] = append([]Card(nil), row...)
+		}
+	}
+	return &clone
 }
 
 //StructToJSON function
@@ -540,6 +654,7 @@ type InputWidget struct {
 	Width           Integer
 	Height          Integer
 	Index           Integer
+	Status          string
 	shouldDrawIndex bool
 }
 
@@ -681,6 +796,10 @@ func (inputWidget *InputWidget) GetVisualArray() [][]rune {
 	for index = 0; index < inputWidget.Width; index++ {
 		emptyRow = append(emptyRow, tcell.RuneHLine)
 	}
+	status := []rune(" " + inputWidget.Status + " ")
+	if inputWidget.Status != "" && Integer(len(status))+1 < inputWidget.Width {
+		copy(emptyRow[inputWidget.Width-Integer(len(status))-1:], status)
+	}
 	array = append(array, emptyRow)
 
 	var inputRow []rune
@@ -744,7 +863,8 @@ func (ui *UI) Update(width, height Integer) {
 
 //AppManager structure
 type AppManager struct {
-	LocalIPs       []net.IP
+	LocalIPs       []LocalAddress
+	ListenAddress  LocalAddress
 	ServerIP       net.IP
 	ServerPort     Integer
 	ServerAddress  string
@@ -754,6 +874,16 @@ type AppManager struct {
 	ScreenMutex    sync.Mutex
 	Timer          *time.Timer
 	CommandChannel chan []rune
+	Player         *Player
+	Connection     *Connection
+	Match          *Match
+	Seat           Integer
+	Lobby          *Lobby
+	SessionToken   string
+	Transport      Transport
+	PlayerName     string
+	Muted          map[string]bool
+	HandPrompt     *HandPrompt
 }
 
 //GetScreenWidth function
@@ -834,13 +964,25 @@ func (appManager *AppManager) GetScreenSize() (Integer, Integer) {
 	return Integer(width), Integer(height)
 }
 
-//Tick function
+//Tick function runs on TimerLoop, it holds ScreenMutex while it reads the connection that Reconnect may replace
 func (appManager *AppManager) Tick() {
 	appManager.Timer.Reset(256 * time.Millisecond)
+	appManager.ScreenMutex.Lock()
 	appManager.UI.InputWidget.Tick()
+	if appManager.Connection != nil {
+		appManager.UI.InputWidget.Status = appManager.Connection.QualityString()
+	}
+	appManager.ScreenMutex.Unlock()
 	appManager.UpdateScreen()
 }
 
+//SetConnection function replaces the connection to the other side while holding ScreenMutex, Tick reads it
+func (appManager *AppManager) SetConnection(connection *Connection) {
+	appManager.ScreenMutex.Lock()
+	defer appManager.ScreenMutex.Unlock()
+	appManager.Connection = connection
+}
+
 //ResetTimer function
 func (appManager *AppManager) ResetTimer() {
 	appManager.Timer.Reset(256 * time.Millisecond)
@@ -855,7 +997,7 @@ func (appManager *AppManager) SetTimer() {
 
 //AskServerOrClient function
 func (appManager *AppManager) AskServerOrClient() {
-	appManager.WriteEntry("Which network role dou you want to become(server/client)?")
+	appManager.WriteEntry("Which network role dou you want to become(server/client/peer)?")
 	appManager.UpdateScreen()
 a:
 	for {
@@ -870,44 +1012,50 @@ a:
 				appManager.WriteEntryAndUpdate("Ok, now you are a client")
 				appManager.Type = ClientApplication
 				break a
+			} else if strings.EqualFold(string(command), "peer") {
+				appManager.WriteEntryAndUpdate("Ok, now you are a peer, both players run the match in lockstep")
+				appManager.Type = PeerApplication
+				break a
 			} else {
-				appManager.WriteEntryAndUpdate("Incorrect answer(" + string(command) + "), choose between: server/client, and try again")
+				appManager.WriteEntryAndUpdate("Incorrect answer(" + string(command) + "), choose between: server/client/peer, and try again")
 			}
 		}
 	}
 }
 
-//FindLocalIPs function
+//FindLocalIPs function lists the addresses of the interfaces that are up, labeled and sorted with the best ones first,
+//plus the option of listening on all interfaces. Errors are reported in the buffer instead of stopping the game
 func (appManager *AppManager) FindLocalIPs() {
-	ips := make([]net.IP, 0)
+	addresses := []LocalAddress{AllInterfaces}
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		panic(err)
+		appManager.WriteEntry(fmt.Sprint("Could not list the network interfaces: ", err))
 	}
 	for _, i := range ifaces {
-		//appManager.WriteEntryAndUpdate(StructToJSONPretty(i))
+		if i.Flags&net.FlagUp == 0 {
+			continue
+		}
 		addrs, err := i.Addrs()
 		if err != nil {
-			panic(err)
+			appManager.WriteEntry(fmt.Sprint("Could not list the addresses of ", i.Name, ": ", err))
+			continue
 		}
 		for _, addr := range addrs {
-			var ip net.IP
 			switch v := addr.(type) {
 			case *net.IPNet:
-				ip = v.IP
-				ips = append(ips, ip)
+				addresses = append(addresses, NewLocalAddress(v.IP, i.Name))
 			case *net.IPAddr:
-				ip = v.IP
-				ips = append(ips, ip)
+				addresses = append(addresses, NewLocalAddress(v.IP, i.Name))
 			}
 		}
 	}
-	appManager.LocalIPs = ips
+	SortLocalAddresses(addresses)
+	appManager.LocalIPs = addresses
 }
 
-//AskForIP function
+//AskForIP function asks which local address to listen on
 func (appManager *AppManager) AskForIP() {
-	appManager.WriteEntryAndUpdate("Choose an address(type an index number):")
+	appManager.WriteEntryAndUpdate("Choose an address(type an index number, 1 is usually the right one):")
 	var index Integer
 	ips := appManager.LocalIPs
 	for index = 0; index < Integer(len(ips)); index++ {
@@ -929,9 +1077,9 @@ a:
 				} else if selection > Integer(len(ips)) {
 					appManager.WriteEntryAndUpdate("The index must be smaller than " + strconv.Itoa(len(ips)+1))
 				} else {
-					appManager.ServerIP = ips[selection-1]
+					appManager.ListenAddress = ips[selection-1]
 					appManager.WriteEntryAndUpdate("Great, you have choosen address " +
-						appManager.ServerIP.String() +
+						appManager.ListenAddress.String() +
 						" at index " +
 						strconv.Itoa(int(selection)))
 					break a
@@ -942,47 +1090,102 @@ a:
 	}
 }
 
-//ListenForConnection function
+//ListenForConnection function serves the lobby on the chosen address until the host types "cancel",
+//"tournaments" shows the brackets of the tournaments of the lobby
 func (appManager *AppManager) ListenForConnection() {
-	var portInteger Integer
-a:
-	for portInteger = 2048; portInteger <= 32000; portInteger++ {
-		//timeout := time.Second
-		addressString := net.JoinHostPort(appManager.ServerIP.String(), strconv.Itoa(int(portInteger)))
-		appManager.WriteEntry(addressString)
-		listener, err := net.Listen("tcp", addressString)
-		if err != nil {
-			// handle error
-			appManager.WriteEntry(fmt.Sprint("Server error: ", err))
-		} else {
-			appManager.WriteEntry("Server at " + addressString)
-			connection, err := listener.Accept()
-			if err != nil {
-				appManager.WriteEntry(fmt.Sprint("tcp server accept error: ", err))
+	listener := appManager.Listen()
+	if listener == nil {
+		return
+	}
+	defer listener.Close()
+	address := listener.Addr().String()
+	appManager.WriteEntryAndUpdate("Lobby server at tcp://" + address + " and ws://" + address + WebSocketPath)
+	appManager.Lobby = NewLobby(listener, appManager.WriteEntryAndUpdate)
+	if tcpAddress, ok := listener.Addr().(*net.TCPAddr); ok {
+		go appManager.Lobby.Announce(Integer(tcpAddress.Port), "tcp://")
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- appManager.Lobby.Serve()
+	}()
+	appManager.WriteEntryAndUpdate("Type \"tournaments\" to see the tournaments or \"cancel\" to close the lobby")
+	for {
+		select {
+		case command := <-appManager.CommandChannel:
+			if strings.EqualFold(string(command), "cancel") {
+				appManager.Lobby.Shutdown()
+			} else if strings.EqualFold(string(command), "tournaments") {
+				appManager.WriteEntryAndUpdate(appManager.Lobby.TournamentsString())
+			}
+		case err := <-served:
+			if appManager.Lobby.ShuttingDown() {
+				appManager.WriteEntryAndUpdate("Lobby closed")
 			} else {
-				appManager.WriteEntry("Connection succesful")
-				appManager.WriteEntry(StructToJSONPretty(connection))
-				break a
+				appManager.WriteEntryAndUpdate(fmt.Sprint("tcp server accept error: ", err))
 			}
+			return
 		}
 	}
-	appManager.UpdateScreen()
+}
+
+//AskListenPort function asks the port to listen on, 0 lets the operating system choose a free one.
+//It returns false if the player types "cancel"
+func (appManager *AppManager) AskListenPort() (Integer, bool) {
+	appManager.WriteEntryAndUpdate("Enter the port to listen on (for example " + strconv.Itoa(DefaultServerPort) + ", 0 lets the system choose a free one, \"cancel\" to go back):")
+	for {
+		command := strings.TrimSpace(string(appManager.ReadCommand()))
+		if strings.EqualFold(command, "cancel") {
+			return 0, false
+		}
+		port, err := strconv.Atoi(command)
+		if err != nil || port < 0 || port > 65535 {
+			appManager.WriteEntryAndUpdate("The port must be an integer between 0 and 65535")
+			continue
+		}
+		return Integer(port), true
+	}
+}
+
+//Listen function listens on ListenAddress at the port the player chooses, asking again while it fails.
+//It returns nil if the player cancels
+func (appManager *AppManager) Listen() net.Listener {
+	for {
+		port, ok := appManager.AskListenPort()
+		if !ok {
+			return nil
+		}
+		listener, err := appManager.Transport.Listen(net.JoinHostPort(appManager.ListenAddress.Host(), strconv.Itoa(int(port))))
+		if err == nil {
+			return listener
+		}
+		appManager.WriteEntryAndUpdate(fmt.Sprint("Server error: ", err))
+	}
 }
 
 func (appManager *AppManager) DialServer() {
 	appManager.WriteEntryAndUpdate("Connecting to server address: " + appManager.ServerAddress)
-	connection, err := net.Dial("tcp", appManager.ServerAddress)
+	connection, err := appManager.Transport.Dial(appManager.ServerAddress)
 	if err != nil {
 		appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", err))
 	} else {
-		appManager.WriteEntry("Connection succesful")
-		appManager.WriteEntryAndUpdate(StructToJSONPretty(connection))
-
+		appManager.WriteEntryAndUpdate("Connection succesful with " + connection.RemoteAddr().String())
+		appManager.SetConnection(NewConnection(connection))
+		if err := appManager.Connection.Handshake(); err != nil {
+			appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", err))
+			appManager.Connection.Close()
+			appManager.SetConnection(nil)
+		}
 	}
 }
 
+//TrustServer function tells the player that a new TLS server was pinned
+func (appManager *AppManager) TrustServer(fingerprint string) {
+	appManager.WriteEntryAndUpdate("First connection to this server, its certificate fingerprint is " + fingerprint +
+		", compare it with the one the server shows")
+}
+
 func (appManager *AppManager) AskServerPort() {
-	appManager.WriteEntryAndUpdate("Enter the server port (integer between 2048 and 32000 inclusive):")
+	appManager.WriteEntryAndUpdate("Enter the server port (integer between 1 and 65535 inclusive):")
 a:
 	for {
 		select {
@@ -991,13 +1194,13 @@ a:
 			portString := string(command)
 			portInt, err := strconv.Atoi(portString)
 			if err != nil {
-				appManager.WriteEntryAndUpdate("Server port must be an integer between 2048 and 32000 ")
+				appManager.WriteEntryAndUpdate("Server port must be an integer between 1 and 65535")
 			} else {
 				appManager.ServerPort = Integer(portInt)
-				if appManager.ServerPort < 2048 || appManager.ServerPort > 32000 {
-					appManager.WriteEntryAndUpdate("Server port must be an integer between 2048 and 32000 ")
+				if appManager.ServerPort < 1 || appManager.ServerPort > 65535 {
+					appManager.WriteEntryAndUpdate("Server port must be an integer between 1 and 65535")
 				} else {
-					appManager.ServerAddress = net.JoinHostPort(appManager.ServerIP.String(), portString)
+					appManager.ServerAddress = net.JoinHostPort(appManager.ServerIP.String(), strconv.Itoa(portInt))
 					appManager.DialServer()
 					break a
 				}
@@ -1007,13 +1210,18 @@ a:
 }
 
 func (appManager *AppManager) ConnectToServer() {
-	appManager.WriteEntryAndUpdate("Enter a server IP address:")
+	appManager.WriteEntryAndUpdate("Enter a server IP address, or a full tcp://, tls://, ws:// or wss:// address:")
 a:
 	for {
 		select {
 		case command := <-appManager.CommandChannel:
 			//PrettyLog(fmt.Sprint("Tick at", t))
 			ipString := string(command)
+			if strings.Contains(ipString, "://") {
+				appManager.ServerAddress = ipString
+				appManager.DialServer()
+				break a
+			}
 			appManager.ServerIP = net.ParseIP(ipString)
 			if appManager.ServerIP == nil {
 				appManager.WriteEntryAndUpdate("Invalid server IP address, try again")
@@ -1038,18 +1246,135 @@ func (appManager *AppManager) PlayMultiplayer() {
 		appManager.FindLocalIPs()
 		appManager.AskForIP()
 		appManager.ListenForConnection()
+	} else if appManager.Type == PeerApplication {
+		appManager.PlayPeer()
 	} else {
-		appManager.ConnectToServer()
+		if !appManager.DiscoverServer() {
+			appManager.ConnectToServer()
+		}
+		if appManager.Connection != nil {
+			appManager.PlayLobby()
+		}
 	}
 }
+
 func (appManager *AppManager) PlaySolo() {
-	appManager.WriteEntry("Escoje cuatro cartas para tu mano inicial")
+	appManager.Player = &Player{Credit: StartingCredit}
+	appManager.WriteEntry("Escoje el modo de 1 jugador")
+	appManager.WriteEntry("A) Mano inicial")
+	appManager.WriteEntry("B) Draft contra bots")
+	appManager.WriteEntryAndUpdate("C) Campaña")
+a:
+	for {
+		command := string(appManager.ReadCommand())
+		if strings.EqualFold(command, "a") {
+			indices := appManager.ChooseStartingHand(appManager.Player.Credit)
+			appManager.Player.Hand = NewStartingHand(indices)
+			appManager.Player.Credit -= HandCost(indices)
+			break a
+		} else if strings.EqualFold(command, "b") {
+			appManager.PlayDraft()
+			break a
+		} else if strings.EqualFold(command, "c") {
+			appManager.PlayCampaign()
+			break a
+		} else {
+			appManager.WriteEntryAndUpdate("Recuerda escribir una de las Opciones (A,B,C)")
+		}
+	}
+}
+
+//AskStartingHand function shows the cards a starting hand of size cards can be chosen from, only the allowed ones
+//unless allowed is nil
+func (appManager *AppManager) AskStartingHand(size Integer, allowed map[Integer]bool) {
+	appManager.WriteEntry("Escoje " + strconv.Itoa(int(size)) + " cartas para tu mano inicial")
 	for i:=0; i<len(ArregloDeCartas);i++{
-		
+		if allowed != nil && !allowed[Integer(i)] {
+			continue
+		}
 		appManager.WriteEntry(strconv.Itoa(i+1)+")\n"+StructToJSONPretty(ArregloDeCartas[i].ObtenerInterfaz()))
 
 	}
-	appManager.UpdateScreen()
+	appManager.WriteEntryAndUpdate("Escribe los numeros o los nombres de las cartas, por ejemplo: 1 3 5 7 o mago ninja")
+}
+
+//HandPrompt structure is a starting hand of Size cards being chosen among the Allowed ones, all of them if it is nil.
+//Indices is the selection waiting to be confirmed, nil while the player still has to select the cards,
+//and MulliganUsed is set once the player chose again
+type HandPrompt struct {
+	Credit       Integer
+	Size         Integer
+	Allowed      map[Integer]bool
+	Indices      []Integer
+	MulliganUsed bool
+}
+
+//NewHandPrompt function asks for a starting hand that fits in credit, the commands of the player go to Feed
+func (appManager *AppManager) NewHandPrompt(credit Integer) *HandPrompt {
+	return appManager.NewRestrictedHandPrompt(StartingHandSize, credit, nil)
+}
+
+//NewRestrictedHandPrompt function asks for a hand of size allowed cards that fits in credit
+func (appManager *AppManager) NewRestrictedHandPrompt(size, credit Integer, allowed map[Integer]bool) *HandPrompt {
+	appManager.AskStartingHand(size, allowed)
+	return &HandPrompt{Credit: credit, Size: size, Allowed: allowed}
+}
+
+//Feed function advances the prompt with a command of the player: a card selection, then its confirmation or the mulligan.
+//It returns the hand as indices of ArregloDeCartas and true once the player confirmed it
+func (prompt *HandPrompt) Feed(appManager *AppManager, command string) ([]Integer, bool) {
+	if prompt.Indices == nil {
+		indices, err := ParseCardSelection(command)
+		if err == nil {
+			err = ValidateHand(indices, prompt.Size, prompt.Credit)
+		}
+		for _, index := range indices {
+			if err == nil && prompt.Allowed != nil && !prompt.Allowed[index] {
+				err = errors.New("La carta " + ArregloDeCartas[index].Name + " no esta disponible")
+			}
+		}
+		if err != nil {
+			appManager.WriteEntryAndUpdate(err.Error() + ", intentalo de nuevo")
+			return nil, false
+		}
+		cost := HandCost(indices)
+		summary := "Tu mano inicial:"
+		for index, card := range NewStartingHand(indices) {
+			summary += "\n" + strconv.Itoa(index+1) + ") " + card.Name + " (costo " + strconv.Itoa(int(card.Cost)) + ")"
+		}
+		summary += "\nCosto total: " + strconv.Itoa(int(cost)) +
+			", credito restante: " + strconv.Itoa(int(prompt.Credit-cost))
+		appManager.WriteEntry(summary)
+		if prompt.MulliganUsed {
+			appManager.WriteEntryAndUpdate("Ya usaste tu mulligan, esta es tu mano definitiva")
+			return indices, true
+		}
+		prompt.Indices = indices
+		appManager.WriteEntryAndUpdate("A) Confirmar mano\nB) Mulligan: volver a escoger una sola vez")
+		return nil, false
+	}
+	if strings.EqualFold(command, "a") {
+		appManager.WriteEntryAndUpdate("Mano confirmada")
+		return prompt.Indices, true
+	} else if strings.EqualFold(command, "b") {
+		prompt.MulliganUsed = true
+		prompt.Indices = nil
+		appManager.AskStartingHand(prompt.Size, prompt.Allowed)
+	} else {
+		appManager.WriteEntryAndUpdate("Recuerda escribir una de las Opciones (A,B)")
+	}
+	return nil, false
+}
+
+//ChooseStartingHand function lets the player pick a starting hand that fits in credit, confirm it and take one mulligan.
+//It returns the confirmed hand as indices of ArregloDeCartas
+func (appManager *AppManager) ChooseStartingHand(credit Integer) []Integer {
+	prompt := appManager.NewHandPrompt(credit)
+	for {
+		if indices, done := prompt.Feed(appManager, string(appManager.ReadCommand())); done {
+			return indices
+		}
+	}
 }
 
 
@@ -1107,16 +1432,13 @@ func (appManager *AppManager) WriteEntryAndUpdate(e string) {
 
 //WriteEntry function
 func (appManager *AppManager) WriteEntry(e string) {
+	appManager.ScreenMutex.Lock()
+	defer appManager.ScreenMutex.Unlock()
 	appManager.UI.BufferWidget.AppendString(e)
 	appManager.UI.BufferWidget.UpdateIndex()
 }
 
 func NewAppManager() *AppManager {
-	var appManager AppManager
-	bufferWidget := NewBufferWidget()
-	inputWidget := NewInputWidget()
-	ui := NewUI(bufferWidget, inputWidget, 16, 8)
-	appManager.UI = ui
 	screen, err := tcell.NewScreen()
 	if err != nil {
 		panic(err)
@@ -1128,8 +1450,22 @@ func NewAppManager() *AppManager {
 	Foreground(tcell.ColorBlack).
 	Background(tcell.ColorWhite))
 	*/
+	appManager := NewAppManagerWithScreen(screen)
+	appManager.Transport = &NetworkTransport{OnNewServer: appManager.TrustServer}
+	return appManager
+}
+
+//NewAppManagerWithScreen function creates an app manager that draws on an initialized screen, tests use
+//a tcell.SimulationScreen, set Transport to a MemoryHub and send commands through CommandChannel
+func NewAppManagerWithScreen(screen tcell.Screen) *AppManager {
+	var appManager AppManager
+	bufferWidget := NewBufferWidget()
+	inputWidget := NewInputWidget()
+	ui := NewUI(bufferWidget, inputWidget, 16, 8)
+	appManager.UI = ui
 	appManager.Screen = screen
 	appManager.CommandChannel = make(chan []rune)
+	appManager.UI.Update(appManager.GetScreenSize())
 	return &appManager
 }
 
@@ -1204,6 +1540,12 @@ func (appManager *AppManager) Execute() {
 	go appManager.TimerLoop()
 	go appManager.LogicLoop()
 	appManager.EventLoop()
+	if appManager.Connection != nil {
+		appManager.Connection.Close()
+	}
+	if appManager.Lobby != nil {
+		appManager.Lobby.Listener.Close()
+	}
 	appManager.Screen.Fini()
 }
 
@@ -1227,6 +1569,12 @@ func NewUI(bufferWidget *BufferWidget, inputWidget *InputWidget, minimumWidth, m
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := Serve(os.Args[2:]); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
 	appManager := NewAppManager()
 	appManager.Execute()
 }
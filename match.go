@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Fases de una partida
+const (
+	ChoosingHandsPhase Integer = iota
+	DeployingPhase
+	FinishedPhase
+)
+
+//Tamaño del tablero de cada jugador
+const (
+	BoardRows    Integer = 2
+	BoardColumns Integer = 4
+)
+
+//HandRejectedReason starts the error sent for a starting hand that is not valid, the client asks the player for another one
+const HandRejectedReason = "starting hand rejected: "
+
+//Match structure is the state machine of a multiplayer match.
+//The server changes it only through Apply, which validates an intent and turns it into events,
+//clients change their copy only through ApplyEvent with the events the server broadcasts.
+//Seed is shared by both peers of a lockstep match so the engine takes the same random decisions on both
+type Match struct {
+	Players    []*Player
+	HandChosen []bool
+	Ready      []bool
+	Phase      Integer
+	Seed       int64
+}
+
+//NewMatch function creates a match in which every seat still has to choose a starting hand
+func NewMatch(seats Integer) *Match {
+	var match Match
+	for seat := Integer(0); seat < seats; seat++ {
+		player := &Player{Credit: StartingCredit}
+		player.Board = make([][]Card, BoardRows)
+		for row := range player.Board {
+			player.Board[row] = make([]Card, BoardColumns)
+		}
+		match.Players = append(match.Players, player)
+	}
+	match.HandChosen = make([]bool, seats)
+	match.Ready = make([]bool, seats)
+	match.Phase = ChoosingHandsPhase
+	return &match
+}
+
+//Apply function validates the intent of a seat and returns the events it produces, already applied to the match
+func (match *Match) Apply(seat Integer, intent Message) ([]Message, error) {
+	if seat < 0 || seat >= Integer(len(match.Players)) {
+		return nil, errors.New("seat " + strconv.Itoa(int(seat)) + " does not exist")
+	}
+	player := match.Players[seat]
+	var events []Message
+	switch intent := intent.(type) {
+	case *StartingHandMessage:
+		if match.Phase != ChoosingHandsPhase || match.HandChosen[seat] {
+			return nil, errors.New("the starting hand can only be chosen once, at the start of the match")
+		}
+		if err := ValidateStartingHand(intent.Cards, player.Credit); err != nil {
+			return nil, errors.New(HandRejectedReason + err.Error())
+		}
+		events = append(events, &HandChosenMessage{Seat: seat, Cards: intent.Cards, Count: Integer(len(intent.Cards)), Cost: HandCost(intent.Cards)})
+	case *DeployMessage:
+		if match.Phase != DeployingPhase || match.Ready[seat] {
+			return nil, errors.New("cards can only be deployed during the deploy phase")
+		}
+		if intent.HandIndex < 0 || intent.HandIndex >= Integer(len(player.Hand)) {
+			return nil, fmt.Errorf("there is no card %d in your hand", intent.HandIndex+1)
+		}
+		if intent.Row < 0 || intent.Row >= BoardRows || intent.Column < 0 || intent.Column >= BoardColumns {
+			return nil, fmt.Errorf("cell %d %d is outside the %dx%d board", intent.Row+1, intent.Column+1, BoardRows, BoardColumns)
+		}
+		if player.Board[intent.Row][intent.Column].Name != "" {
+			return nil, fmt.Errorf("cell %d %d is already taken", intent.Row+1, intent.Column+1)
+		}
+		events = append(events, &CardDeployedMessage{Seat: seat, HandIndex: intent.HandIndex, Row: intent.Row, Column: intent.Column, Card: player.Hand[intent.HandIndex]})
+	case *ReadyMessage:
+		if match.Phase != DeployingPhase || match.Ready[seat] {
+			return nil, errors.New("you can only get ready once, during the deploy phase")
+		}
+		events = append(events, &PlayerReadyMessage{Seat: seat})
+	default:
+		return nil, errors.New(intent.MessageType() + " is not a valid intent")
+	}
+	for _, event := range events {
+		match.ApplyEvent(event)
+	}
+	if match.Phase == ChoosingHandsPhase && AllTrue(match.HandChosen) {
+		events = append(events, &PhaseMessage{Phase: DeployingPhase})
+		match.ApplyEvent(events[len(events)-1])
+	} else if match.Phase == DeployingPhase && AllTrue(match.Ready) {
+		events = append(events, &PhaseMessage{Phase: FinishedPhase})
+		match.ApplyEvent(events[len(events)-1])
+	}
+	return events, nil
+}
+
+//Clone function returns a copy of the match that shares no state with the original
+func (match *Match) Clone() *Match {
+	clone := *match
+	clone.Players = make([]*Player, len(match.Players))
+	for index, player := range match.Players {
+		clone.Players[index] = player.Clone()
+	}
+	clone.HandChosen = append([]bool(nil), match.HandChosen...)
+	clone.Ready = append([]bool(nil), match.Ready...)
+	return &clone
+}
+
+//ApplyEvent function changes the match according to an event produced by Apply
+func (match *Match) ApplyEvent(event Message) {
+	switch event := event.(type) {
+	case *HandChosenMessage:
+		player := match.Players[event.Seat]
+		if event.Cards != nil {
+			player.Hand = NewStartingHand(event.Cards)
+		} else {
+			player.HandCount = event.Count
+		}
+		player.Credit -= event.Cost
+		match.HandChosen[event.Seat] = true
+	case *CardDeployedMessage:
+		player := match.Players[event.Seat]
+		player.Board[event.Row][event.Column] = event.Card
+		if event.HandIndex >= 0 {
+			player.Hand = append(player.Hand[:event.HandIndex:event.HandIndex], player.Hand[event.HandIndex+1:]...)
+		} else {
+			player.HandCount--
+		}
+	case *PlayerReadyMessage:
+		match.Ready[event.Seat] = true
+	case *PhaseMessage:
+		match.Phase = event.Phase
+	}
+}
+
+//ViewFor function returns what seat may see of the match: its own hand, the boards of everyone
+//and only the number of cards in the other hands and in every deck
+func (match *Match) ViewFor(seat Integer) *Match {
+	view := match.Clone()
+	for index, player := range view.Players {
+		player.DeckCount = Integer(len(player.Deck))
+		player.Deck = nil
+		if Integer(index) != seat {
+			player.HandCount = Integer(len(player.Hand))
+			player.Hand = nil
+		}
+	}
+	return view
+}
+
+//RedactEvent function returns the event as seat may see it, hiding the cards of the other hands
+func RedactEvent(event Message, seat Integer) Message {
+	switch event := event.(type) {
+	case *HandChosenMessage:
+		if event.Seat != seat {
+			return &HandChosenMessage{Seat: event.Seat, Count: event.Count, Cost: event.Cost}
+		}
+	case *CardDeployedMessage:
+		if event.Seat != seat {
+			redacted := *event
+			redacted.HandIndex = -1
+			return &redacted
+		}
+	}
+	return event
+}
+
+//AllTrue function reports whether every value is true
+func AllTrue(values []bool) bool {
+	for _, value := range values {
+		if !value {
+			return false
+		}
+	}
+	return true
+}
+
+//DescribeEvent function explains an event from the point of view of the given seat
+func DescribeEvent(event Message, seat Integer) string {
+	who := func(eventSeat Integer) string {
+		if eventSeat == seat {
+			return "You"
+		}
+		return "Your opponent"
+	}
+	switch event := event.(type) {
+	case *HandChosenMessage:
+		return who(event.Seat) + " chose a starting hand of " + strconv.Itoa(int(event.Count)) + " cards"
+	case *CardDeployedMessage:
+		return who(event.Seat) + " deployed " + event.Card.Name + " at " + strconv.Itoa(int(event.Row+1)) + " " + strconv.Itoa(int(event.Column+1))
+	case *PlayerReadyMessage:
+		return who(event.Seat) + " finished deploying"
+	case *PlayerDisconnectedMessage:
+		return who(event.Seat) + " disconnected, the seat is kept for " + ReconnectGracePeriod.String()
+	case *PlayerReconnectedMessage:
+		return who(event.Seat) + " reconnected"
+	case *PhaseMessage:
+		switch event.Phase {
+		case DeployingPhase:
+			return "Deploy phase: type \"deploy <hand card> <row> <column>\", \"board\" to see your board and \"ready\" when you are done"
+		case FinishedPhase:
+			return "Both boards are set"
+		}
+	}
+	return event.MessageType()
+}
+
+//BoardString function draws the board of a player, one row per line
+func BoardString(player *Player) string {
+	var lines []string
+	for _, row := range player.Board {
+		var cells []string
+		for _, card := range row {
+			if card.Name == "" {
+				cells = append(cells, ".")
+			} else {
+				cells = append(cells, card.Name)
+			}
+		}
+		lines = append(lines, strings.Join(cells, " | "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//HandString function lists the hand of a player with the numbers used by the deploy command
+func HandString(player *Player) string {
+	var lines []string
+	for index, card := range player.Hand {
+		lines = append(lines, strconv.Itoa(index+1)+") "+card.Name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//ParseMatchCommand function turns a command typed during the match into an intent
+func ParseMatchCommand(command string) (Message, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+	switch strings.ToLower(fields[0]) {
+	case "deploy":
+		if len(fields) != 4 {
+			return nil, errors.New("usage: deploy <hand card> <row> <column>")
+		}
+		var numbers [3]Integer
+		for index := range numbers {
+			number, err := strconv.Atoi(fields[index+1])
+			if err != nil {
+				return nil, errors.New("usage: deploy <hand card> <row> <column>")
+			}
+			numbers[index] = Integer(number - 1)
+		}
+		return &DeployMessage{HandIndex: numbers[0], Row: numbers[1], Column: numbers[2]}, nil
+	case "ready":
+		return &ReadyMessage{}, nil
+	}
+	return nil, errors.New("unknown command " + fields[0])
+}
+
+//HandleMessage function applies an event from the server to the local copy of the match
+func (appManager *AppManager) HandleMessage(message Message) {
+	switch message := message.(type) {
+	case *ErrorMessage:
+		appManager.WriteEntryAndUpdate("Rejected: " + message.Reason)
+		if strings.HasPrefix(message.Reason, HandRejectedReason) && !appManager.Match.HandChosen[appManager.Seat] {
+			appManager.PromptStartingHand()
+		}
+		return
+	case *SnapshotMessage:
+		appManager.Match = message.Match
+		appManager.Seat = message.Seat
+		appManager.Player = appManager.Match.Players[message.Seat]
+		appManager.WriteEntryAndUpdate("Reconnected to the match")
+		if !appManager.Match.HandChosen[appManager.Seat] {
+			appManager.PromptStartingHand()
+		}
+		return
+	}
+	appManager.Match.ApplyEvent(message)
+	appManager.WriteEntryAndUpdate(DescribeEvent(message, appManager.Seat))
+}
+
+//SendMessage function sends a message to the server and reports connection errors
+func (appManager *AppManager) SendMessage(message Message) {
+	if err := appManager.Connection.Send(message); err != nil {
+		appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", err))
+	}
+}
+
+//PromptStartingHand function asks for a starting hand unless the player is already choosing one,
+//PlayMatch sends it to the server as an intent once the player confirms it
+func (appManager *AppManager) PromptStartingHand() {
+	if appManager.HandPrompt == nil {
+		appManager.HandPrompt = appManager.NewHandPrompt(appManager.Match.Players[appManager.Seat].Credit)
+	}
+}
+
+//Reconnect function dials the server again and resumes the session of the match, within the grace period
+func (appManager *AppManager) Reconnect() bool {
+	deadline := time.Now().Add(ReconnectGracePeriod)
+	for time.Now().Before(deadline) {
+		time.Sleep(ReconnectInterval)
+		appManager.WriteEntryAndUpdate("Reconnecting to " + appManager.ServerAddress)
+		conn, err := appManager.Transport.Dial(appManager.ServerAddress)
+		if err != nil {
+			continue
+		}
+		appManager.SetConnection(NewConnection(conn))
+		if err := appManager.Connection.Handshake(); err != nil {
+			appManager.WriteEntryAndUpdate(fmt.Sprint("Could not resume the match: ", err))
+			return false
+		}
+		appManager.SendMessage(&ResumeMessage{Token: appManager.SessionToken})
+		appManager.SendName(false)
+		message, ok := <-appManager.Connection.Incoming
+		if !ok {
+			continue
+		}
+		if rejection, ok := message.(*ErrorMessage); ok {
+			appManager.WriteEntryAndUpdate("Could not resume the match: " + rejection.Reason)
+			return false
+		}
+		appManager.HandleMessage(message)
+		return true
+	}
+	appManager.WriteEntryAndUpdate("Could not reconnect in time, the match was forfeited")
+	return false
+}
+
+//PlayMatch function plays the match of the joined room from the given seat.
+//The server owns the match, the client only sends intents and applies the events it receives.
+//It returns false if the connection was lost, or closed by the server, which forfeits the match and revokes the session
+//of a client it disconnects, so there is no point in resuming it.
+//The starting hand is chosen through HandPrompt, so chat, leave and the events of the opponent work meanwhile
+func (appManager *AppManager) PlayMatch(seat Integer) bool {
+	appManager.Match = NewMatch(RoomSeats)
+	appManager.Seat = seat
+	appManager.Player = appManager.Match.Players[seat]
+	appManager.WriteEntryAndUpdate("The match started, type \"leave\" at any moment to forfeit it")
+	appManager.HandPrompt = nil
+	appManager.PromptStartingHand()
+	for appManager.Match.Phase != FinishedPhase {
+		select {
+		case command := <-appManager.CommandChannel:
+			if appManager.HandleChatCommand(string(command)) {
+				continue
+			}
+			if strings.EqualFold(string(command), "board") {
+				appManager.WriteEntryAndUpdate(BoardString(appManager.Player) + "\nHand:\n" + HandString(appManager.Player))
+				continue
+			}
+			if strings.EqualFold(string(command), "leave") {
+				appManager.SendMessage(&LeaveRoomMessage{})
+				appManager.WriteEntryAndUpdate("You forfeited the match")
+				return true
+			}
+			if appManager.HandPrompt != nil {
+				if cards, done := appManager.HandPrompt.Feed(appManager, string(command)); done {
+					appManager.HandPrompt = nil
+					appManager.SendMessage(&StartingHandMessage{Cards: cards})
+				}
+				continue
+			}
+			intent, err := ParseMatchCommand(string(command))
+			if err != nil {
+				appManager.WriteEntryAndUpdate(err.Error())
+				continue
+			}
+			if appManager.ValidateRequest(intent) {
+				appManager.SendMessage(intent)
+			}
+		case message, ok := <-appManager.Connection.Incoming:
+			if !ok {
+				appManager.WriteEntryAndUpdate(fmt.Sprint("Connection error: ", appManager.Connection.Err()))
+				if !appManager.Connection.HasFeature(ReconnectFeature) || !appManager.Reconnect() {
+					return false
+				}
+				continue
+			}
+			if chat, ok := message.(*ChatMessage); ok {
+				appManager.ShowChat(chat)
+				continue
+			}
+			if registered, ok := message.(*NameRegisteredMessage); ok {
+				appManager.SaveIdentity(registered)
+				continue
+			}
+			if bracket, ok := message.(*BracketMessage); ok {
+				appManager.WriteEntryAndUpdate(bracket.Text)
+				continue
+			}
+			if closed, ok := message.(*RoomClosedMessage); ok {
+				appManager.WriteEntryAndUpdate("The match ended, the room was " + closed.Reason)
+				return true
+			}
+			if rejection, ok := message.(*ErrorMessage); ok && strings.HasPrefix(rejection.Reason, DisconnectedReason) {
+				appManager.WriteEntryAndUpdate("Error: " + rejection.Reason)
+				return false
+			}
+			if left, ok := message.(*PlayerLeftMessage); ok && left.Seat != seat {
+				appManager.WriteEntryAndUpdate("Your opponent left the match")
+				return true
+			}
+			appManager.HandleMessage(message)
+		}
+	}
+	appManager.WriteEntryAndUpdate(BoardString(appManager.Player))
+	return true
+}
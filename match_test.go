@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"strings"
+	"testing"
+)
+
+//TestStartingHandRejection checks that the server marks the rejection of a starting hand and that the client
+//asks for another hand only after that rejection
+func TestStartingHandRejection(t *testing.T) {
+	match := NewMatch(RoomSeats)
+	match.Players[0].Credit = 0
+	_, rejection := match.Apply(0, &StartingHandMessage{Cards: testHand()})
+	if rejection == nil || !strings.HasPrefix(rejection.Error(), HandRejectedReason) {
+		t.Fatalf("a hand the player can not afford was answered with %v", rejection)
+	}
+	if _, err := match.Apply(1, &ReadyMessage{}); err == nil || strings.HasPrefix(err.Error(), HandRejectedReason) {
+		t.Fatalf("an early ready was answered with %v", err)
+	}
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	appManager := NewAppManagerWithScreen(screen)
+	appManager.Match = NewMatch(RoomSeats)
+	appManager.HandleMessage(&ErrorMessage{Reason: "you can only get ready once, during the deploy phase"})
+	if appManager.HandPrompt != nil {
+		t.Fatal("the client asks for a hand after an error that has nothing to do with it")
+	}
+	appManager.HandleMessage(&ErrorMessage{Reason: rejection.Error()})
+	if appManager.HandPrompt == nil {
+		t.Fatal("the client does not ask for another hand after its hand was rejected")
+	}
+}
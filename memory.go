@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Faults structure describes the network problems a MemoryHub injects in its connections.
+//Reorder is the probability that a write is overtaken by the next one, DropAfter cuts a connection
+//after that many writes, 0 never cuts it
+type Faults struct {
+	Latency   time.Duration
+	Jitter    time.Duration
+	Reorder   float64
+	DropAfter Integer
+}
+
+//MemoryHub structure is an in-memory network: listeners register an address and every dial to it
+//is a net.Pipe whose ends inject the current Faults
+type MemoryHub struct {
+	Faults    Faults
+	mutex     sync.Mutex
+	listeners map[string]*MemoryListener
+	conns     []*FaultyConn
+	nextPort  Integer
+	random    *rand.Rand
+}
+
+//NewMemoryHub function creates a hub whose random faults are reproducible from seed
+func NewMemoryHub(seed int64) *MemoryHub {
+	return &MemoryHub{listeners: make(map[string]*MemoryListener), nextPort: DefaultServerPort, random: rand.New(rand.NewSource(seed))}
+}
+
+//MemoryAddr type is the address of a MemoryListener
+type MemoryAddr string
+
+//Network function
+func (address MemoryAddr) Network() string {
+	return "memory"
+}
+
+//String function
+func (address MemoryAddr) String() string {
+	return string(address)
+}
+
+//MemoryListener structure accepts the connections dialed to its address in a MemoryHub
+type MemoryListener struct {
+	*ConnListener
+	hub *MemoryHub
+}
+
+//Close function frees the address of the listener
+func (listener *MemoryListener) Close() error {
+	listener.hub.mutex.Lock()
+	if listener.hub.listeners[listener.Address.String()] == listener {
+		delete(listener.hub.listeners, listener.Address.String())
+	}
+	listener.hub.mutex.Unlock()
+	return listener.ConnListener.Close()
+}
+
+//Listen function registers address in the hub, port 0 gets a free port
+func (hub *MemoryHub) Listen(address string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if port == "0" {
+		for hub.listeners[net.JoinHostPort(host, strconv.Itoa(int(hub.nextPort)))] != nil {
+			hub.nextPort++
+		}
+		port = strconv.Itoa(int(hub.nextPort))
+	}
+	address = net.JoinHostPort(host, port)
+	if hub.listeners[address] != nil {
+		return nil, errors.New("listen memory " + address + ": address already in use")
+	}
+	listener := &MemoryListener{ConnListener: NewConnListener(MemoryAddr(address)), hub: hub}
+	hub.listeners[address] = listener
+	return listener, nil
+}
+
+//Dial function connects to the listener of address, the scheme of the address is ignored
+func (hub *MemoryHub) Dial(address string) (net.Conn, error) {
+	if index := strings.Index(address, "://"); index >= 0 {
+		address = address[index+3:]
+	}
+	hub.mutex.Lock()
+	listener := hub.listeners[address]
+	if listener == nil {
+		hub.mutex.Unlock()
+		return nil, errors.New("dial memory " + address + ": connection refused")
+	}
+	client, server := net.Pipe()
+	clientConn := NewFaultyConn(client, hub.Faults, hub.random.Int63())
+	serverConn := NewFaultyConn(server, hub.Faults, hub.random.Int63())
+	hub.conns = append(hub.conns, clientConn, serverConn)
+	hub.mutex.Unlock()
+	go listener.Push(serverConn)
+	return clientConn, nil
+}
+
+//Cut function drops every connection of the hub without delivering their pending writes, as if the network went down
+func (hub *MemoryHub) Cut() {
+	hub.mutex.Lock()
+	conns := hub.conns
+	hub.conns = nil
+	hub.mutex.Unlock()
+	for _, conn := range conns {
+		conn.Drop()
+	}
+}
+
+//delivery structure is a write waiting for its latency
+type delivery struct {
+	bytes []byte
+	at    time.Time
+}
+
+//FaultyConn structure is one end of a net.Pipe whose writes are delayed, reordered and cut according to Faults.
+//Writes return at once and are delivered in order by a goroutine, a reordered write waits for the next one.
+//Close delivers the pending writes first, like TCP does, while Drop loses them
+type FaultyConn struct {
+	net.Conn
+	faults    Faults
+	random    *rand.Rand
+	mutex     sync.Mutex
+	writes    Integer
+	held      *delivery
+	queue     chan delivery
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	dropOnce  sync.Once
+}
+
+//NewFaultyConn function wraps conn and starts its delivery goroutine
+func NewFaultyConn(conn net.Conn, faults Faults, seed int64) *FaultyConn {
+	faultyConn := &FaultyConn{
+		Conn:    conn,
+		faults:  faults,
+		random:  rand.New(rand.NewSource(seed)),
+		queue:   make(chan delivery, 64),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go faultyConn.deliver()
+	return faultyConn
+}
+
+//Write function queues a copy of bytes for delivery
+func (conn *FaultyConn) Write(bytes []byte) (int, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.writes++
+	if conn.faults.DropAfter > 0 && conn.writes > conn.faults.DropAfter {
+		conn.Drop()
+		return 0, io.ErrClosedPipe
+	}
+	next := delivery{bytes: append([]byte(nil), bytes...), at: time.Now().Add(conn.faults.Latency)}
+	if conn.faults.Jitter > 0 {
+		next.at = next.at.Add(time.Duration(conn.random.Int63n(int64(conn.faults.Jitter))))
+	}
+	var deliveries []delivery
+	if conn.held != nil {
+		held := *conn.held
+		conn.held = nil
+		if held.at.Before(next.at) {
+			held.at = next.at
+		}
+		deliveries = append(deliveries, next, held)
+	} else if conn.random.Float64() < conn.faults.Reorder {
+		conn.held = &next
+	} else {
+		deliveries = append(deliveries, next)
+	}
+	for _, queued := range deliveries {
+		select {
+		case conn.queue <- queued:
+		case <-conn.closing:
+			return 0, io.ErrClosedPipe
+		case <-conn.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	return len(bytes), nil
+}
+
+//deliver function writes the queued deliveries to the pipe once their time comes,
+//after Close it delivers what is left and closes the pipe
+func (conn *FaultyConn) deliver() {
+	for {
+		select {
+		case queued := <-conn.queue:
+			if !conn.write(queued) {
+				return
+			}
+		case <-conn.closing:
+			for {
+				select {
+				case queued := <-conn.queue:
+					conn.Conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+					if !conn.write(queued) {
+						return
+					}
+				default:
+					conn.Drop()
+					return
+				}
+			}
+		case <-conn.done:
+			return
+		}
+	}
+}
+
+//write function waits for the time of a delivery and writes it, it returns false if the pipe is gone
+func (conn *FaultyConn) write(queued delivery) bool {
+	timer := time.NewTimer(time.Until(queued.at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-conn.done:
+		return false
+	}
+	if _, err := conn.Conn.Write(queued.bytes); err != nil {
+		conn.Drop()
+		return false
+	}
+	return true
+}
+
+//Close function closes the connection once its pending writes are delivered, a write held back
+//to be overtaken is queued last since no write will overtake it anymore
+func (conn *FaultyConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.mutex.Lock()
+		if conn.held != nil {
+			select {
+			case conn.queue <- *conn.held:
+			case <-conn.done:
+			}
+			conn.held = nil
+		}
+		conn.mutex.Unlock()
+		close(conn.closing)
+	})
+	return nil
+}
+
+//Drop function closes the connection at once, losing its pending writes
+func (conn *FaultyConn) Drop() {
+	conn.dropOnce.Do(func() {
+		close(conn.done)
+		conn.Conn.Close()
+	})
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//MaximumFrameSize is the largest envelope, in bytes, a peer may send
+const MaximumFrameSize = 64 * 1024
+
+//Cada cuanto se envia un ping, cuanto silencio indica que el otro lado esta muerto y cuanto puede tardar una escritura
+const (
+	HeartbeatInterval = 5 * time.Second
+	DeadPeerTimeout   = 3 * HeartbeatInterval
+	WriteTimeout      = 10 * time.Second
+)
+
+//Envelope structure is what travels on the wire: a 4 byte big endian length followed by the JSON envelope
+type Envelope struct {
+	Type     string
+	Sequence Integer
+	Payload  json.RawMessage
+}
+
+//Message interface is implemented by every game command sent over the connection
+type Message interface {
+	MessageType() string
+}
+
+//PingMessage structure is a heartbeat, the peer answers it with a PongMessage carrying the same SentAt
+type PingMessage struct {
+	SentAt int64
+}
+
+//MessageType function
+func (message *PingMessage) MessageType() string {
+	return "ping"
+}
+
+//PongMessage structure answers a PingMessage
+type PongMessage struct {
+	SentAt int64
+}
+
+//MessageType function
+func (message *PongMessage) MessageType() string {
+	return "pong"
+}
+
+//StartingHandMessage structure is the intent to start with the given hand, as indices of ArregloDeCartas
+type StartingHandMessage struct {
+	Cards []Integer
+}
+
+//MessageType function
+func (message *StartingHandMessage) MessageType() string {
+	return "starting-hand"
+}
+
+//DraftPickMessage structure picks the card at Index of the current draft pack
+type DraftPickMessage struct {
+	Index Integer
+}
+
+//MessageType function
+func (message *DraftPickMessage) MessageType() string {
+	return "draft-pick"
+}
+
+//DraftPackMessage structure is the pack a seat of a draft room picks from, as indices of ArregloDeCartas,
+//the server picks for the seat once PickTimer seconds pass
+type DraftPackMessage struct {
+	Round     Integer
+	Rounds    Integer
+	Pack      []Integer
+	PickTimer Integer
+}
+
+//MessageType function
+func (message *DraftPackMessage) MessageType() string {
+	return "draft-pack"
+}
+
+//DraftPickedMessage structure confirms the card a seat picked, Automatic is true if the server picked it when the timer ran out
+type DraftPickedMessage struct {
+	Card      Integer
+	Automatic bool
+}
+
+//MessageType function
+func (message *DraftPickedMessage) MessageType() string {
+	return "draft-picked"
+}
+
+//DraftDeckMessage structure ends a draft room with the pool the seat drafted, its new deck
+type DraftDeckMessage struct {
+	Cards []Integer
+}
+
+//MessageType function
+func (message *DraftDeckMessage) MessageType() string {
+	return "draft-deck"
+}
+
+//DeployMessage structure is the intent to move the card at HandIndex of the hand to a board cell
+type DeployMessage struct {
+	HandIndex Integer
+	Row       Integer
+	Column    Integer
+}
+
+//MessageType function
+func (message *DeployMessage) MessageType() string {
+	return "deploy"
+}
+
+//ReadyMessage structure is the intent to end the deploy phase
+type ReadyMessage struct {
+}
+
+//MessageType function
+func (message *ReadyMessage) MessageType() string {
+	return "ready"
+}
+
+//HandChosenMessage structure is the event of a seat starting with the given hand.
+//Other seats receive it without Cards, only with the number of cards and their total cost
+type HandChosenMessage struct {
+	Seat  Integer
+	Cards []Integer
+	Count Integer
+	Cost  Integer
+}
+
+//MessageType function
+func (message *HandChosenMessage) MessageType() string {
+	return "hand-chosen"
+}
+
+//CardDeployedMessage structure is the event of a seat moving a card from its hand to its board.
+//Card is revealed to everyone, other seats receive HandIndex -1
+type CardDeployedMessage struct {
+	Seat      Integer
+	HandIndex Integer
+	Row       Integer
+	Column    Integer
+	Card      Card
+}
+
+//MessageType function
+func (message *CardDeployedMessage) MessageType() string {
+	return "card-deployed"
+}
+
+//PlayerReadyMessage structure is the event of a seat ending its deploy phase
+type PlayerReadyMessage struct {
+	Seat Integer
+}
+
+//MessageType function
+func (message *PlayerReadyMessage) MessageType() string {
+	return "player-ready"
+}
+
+//PhaseMessage structure is the event of the match entering a new phase
+type PhaseMessage struct {
+	Phase Integer
+}
+
+//MessageType function
+func (message *PhaseMessage) MessageType() string {
+	return "phase"
+}
+
+//ListRoomsMessage structure asks the lobby for its rooms
+type ListRoomsMessage struct {
+}
+
+//MessageType function
+func (message *ListRoomsMessage) MessageType() string {
+	return "list-rooms"
+}
+
+//RoomInfo structure describes a lobby room
+type RoomInfo struct {
+	Name    string
+	Players Integer
+	Playing bool
+	Locked  bool
+	Draft   bool
+}
+
+//RoomsMessage structure answers ListRoomsMessage
+type RoomsMessage struct {
+	Rooms []RoomInfo
+}
+
+//MessageType function
+func (message *RoomsMessage) MessageType() string {
+	return "rooms"
+}
+
+//CreateRoomMessage structure asks the lobby to create a room and join it, an empty Password leaves it open.
+//A Draft room drafts decks instead of playing a match
+type CreateRoomMessage struct {
+	Name     string
+	Password string
+	Draft    bool
+}
+
+//MessageType function
+func (message *CreateRoomMessage) MessageType() string {
+	return "create-room"
+}
+
+//JoinRoomMessage structure asks the lobby to join an existing room, Password must match the one of the room
+type JoinRoomMessage struct {
+	Name     string
+	Password string
+}
+
+//MessageType function
+func (message *JoinRoomMessage) MessageType() string {
+	return "join-room"
+}
+
+//LeaveRoomMessage structure leaves the current room, forfeiting its match
+type LeaveRoomMessage struct {
+}
+
+//MessageType function
+func (message *LeaveRoomMessage) MessageType() string {
+	return "leave-room"
+}
+
+//JoinedRoomMessage structure confirms that the client is now in the room.
+//Token lets the client take its seat back with ResumeMessage if the connection drops, Draft tells a draft room apart
+type JoinedRoomMessage struct {
+	Name  string
+	Token string
+	Draft bool
+}
+
+//MessageType function
+func (message *JoinedRoomMessage) MessageType() string {
+	return "joined-room"
+}
+
+//MatchStartMessage structure tells a client that the match of its room started and which seat it plays
+type MatchStartMessage struct {
+	Seat Integer
+}
+
+//MessageType function
+func (message *MatchStartMessage) MessageType() string {
+	return "match-start"
+}
+
+//ResumeMessage structure asks to take back the seat of a session after reconnecting
+type ResumeMessage struct {
+	Token string
+}
+
+//MessageType function
+func (message *ResumeMessage) MessageType() string {
+	return "resume"
+}
+
+//SnapshotMessage structure is the view of the match of the client as it was when it disconnected,
+//the events it missed follow as normal messages
+type SnapshotMessage struct {
+	Seat  Integer
+	Match *Match
+}
+
+//MessageType function
+func (message *SnapshotMessage) MessageType() string {
+	return "snapshot"
+}
+
+//PlayerDisconnectedMessage structure is the event of a seat losing its connection, its seat is kept for ReconnectGracePeriod
+type PlayerDisconnectedMessage struct {
+	Seat Integer
+}
+
+//MessageType function
+func (message *PlayerDisconnectedMessage) MessageType() string {
+	return "player-disconnected"
+}
+
+//PlayerReconnectedMessage structure is the event of a disconnected seat coming back
+type PlayerReconnectedMessage struct {
+	Seat Integer
+}
+
+//MessageType function
+func (message *PlayerReconnectedMessage) MessageType() string {
+	return "player-reconnected"
+}
+
+//PlayerLeftMessage structure is the event of a seat leaving the match, which ends it
+type PlayerLeftMessage struct {
+	Seat Integer
+}
+
+//MessageType function
+func (message *PlayerLeftMessage) MessageType() string {
+	return "player-left"
+}
+
+//ErrorMessage structure tells the peer why its last message was not accepted
+type ErrorMessage struct {
+	Reason string
+}
+
+//MessageType function
+func (message *ErrorMessage) MessageType() string {
+	return "error"
+}
+
+//MessageConstructors maps every envelope type to a function that allocates its payload
+var MessageConstructors = map[string]func() Message{
+	"ping":                func() Message { return &PingMessage{} },
+	"pong":                func() Message { return &PongMessage{} },
+	"starting-hand":       func() Message { return &StartingHandMessage{} },
+	"draft-pick":          func() Message { return &DraftPickMessage{} },
+	"draft-pack":          func() Message { return &DraftPackMessage{} },
+	"draft-picked":        func() Message { return &DraftPickedMessage{} },
+	"draft-deck":          func() Message { return &DraftDeckMessage{} },
+	"deploy":              func() Message { return &DeployMessage{} },
+	"ready":               func() Message { return &ReadyMessage{} },
+	"hand-chosen":         func() Message { return &HandChosenMessage{} },
+	"card-deployed":       func() Message { return &CardDeployedMessage{} },
+	"player-ready":        func() Message { return &PlayerReadyMessage{} },
+	"phase":               func() Message { return &PhaseMessage{} },
+	"list-rooms":          func() Message { return &ListRoomsMessage{} },
+	"rooms":               func() Message { return &RoomsMessage{} },
+	"create-room":         func() Message { return &CreateRoomMessage{} },
+	"join-room":           func() Message { return &JoinRoomMessage{} },
+	"leave-room":          func() Message { return &LeaveRoomMessage{} },
+	"joined-room":         func() Message { return &JoinedRoomMessage{} },
+	"match-start":         func() Message { return &MatchStartMessage{} },
+	"player-left":         func() Message { return &PlayerLeftMessage{} },
+	"resume":              func() Message { return &ResumeMessage{} },
+	"snapshot":            func() Message { return &SnapshotMessage{} },
+	"player-disconnected": func() Message { return &PlayerDisconnectedMessage{} },
+	"player-reconnected":  func() Message { return &PlayerReconnectedMessage{} },
+	"set-name":            func() Message { return &SetNameMessage{} },
+	"name-registered":     func() Message { return &NameRegisteredMessage{} },
+	"chat":                func() Message { return &ChatMessage{} },
+	"lockstep-start":      func() Message { return &LockstepStartMessage{} },
+	"lockstep-command":    func() Message { return &LockstepCommandMessage{} },
+	"lockstep-hash":       func() Message { return &LockstepHashMessage{} },
+	"lockstep-state":      func() Message { return &LockstepStateMessage{} },
+	"queue":               func() Message { return &QueueMessage{} },
+	"leave-queue":         func() Message { return &LeaveQueueMessage{} },
+	"queued":              func() Message { return &QueuedMessage{} },
+	"match-found":         func() Message { return &MatchFoundMessage{} },
+	"confirm-match":       func() Message { return &ConfirmMatchMessage{} },
+	"rating-changed":      func() Message { return &RatingChangedMessage{} },
+	"tournament":          func() Message { return &TournamentMessage{} },
+	"bracket":             func() Message { return &BracketMessage{} },
+	"room-closed":         func() Message { return &RoomClosedMessage{} },
+	"hello":               func() Message { return &HelloMessage{} },
+	"error":               func() Message { return &ErrorMessage{} },
+}
+
+//EncodeMessage function wraps a message in an envelope
+func EncodeMessage(sequence Integer, message Message) (*Envelope, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Type: message.MessageType(), Sequence: sequence, Payload: payload}, nil
+}
+
+//DecodeMessage function unwraps the typed message of an envelope
+func DecodeMessage(envelope *Envelope) (Message, error) {
+	constructor, ok := MessageConstructors[envelope.Type]
+	if !ok {
+		return nil, errors.New("unknown message type " + envelope.Type)
+	}
+	message := constructor()
+	if err := json.Unmarshal(envelope.Payload, message); err != nil {
+		return nil, fmt.Errorf("invalid %s message: %v", envelope.Type, err)
+	}
+	return message, nil
+}
+
+//WriteFrame function writes a length prefixed envelope
+func WriteFrame(writer io.Writer, envelope *Envelope) error {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if len(body) > MaximumFrameSize {
+		return fmt.Errorf("frame of %d bytes is bigger than %d", len(body), MaximumFrameSize)
+	}
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err = writer.Write(frame)
+	return err
+}
+
+//ReadFrame function reads a length prefixed envelope of at most maximum bytes
+func ReadFrame(reader io.Reader, maximum Integer) (*Envelope, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > uint32(maximum) {
+		return nil, fmt.Errorf("frame of %d bytes is bigger than %d", size, maximum)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+//Connection structure owns a net.Conn and its reader, writer and heartbeat goroutines.
+//Pings and pongs are answered here and never reach Incoming. A peer that stays silent
+//for DeadPeerTimeout is considered dead. Incoming is closed when the connection ends, Err then tells why.
+//Features holds the optional features both peers support, it is filled by Handshake
+type Connection struct {
+	Conn      net.Conn
+	Features  map[string]bool
+	Incoming  chan Message
+	outgoing  chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+	errMutex  sync.Mutex
+	err       error
+	rtt       time.Duration
+	frameSize Integer
+	limiter   *RateLimiter
+}
+
+//NewConnection function starts the reader and writer goroutines of conn
+func NewConnection(conn net.Conn) *Connection {
+	return NewLimitedConnection(conn, Limits{FrameSize: MaximumFrameSize})
+}
+
+//NewLimitedConnection function starts the reader and writer goroutines of conn, the peer fails
+//the connection if it breaks limits
+func NewLimitedConnection(conn net.Conn, limits Limits) *Connection {
+	var connection Connection
+	connection.Conn = conn
+	connection.frameSize = limits.FrameSize
+	if limits.Burst > 0 {
+		connection.limiter = NewRateLimiter(limits.Burst, limits.Refill)
+	}
+	connection.Incoming = make(chan Message, 16)
+	connection.outgoing = make(chan Message, 16)
+	connection.done = make(chan struct{})
+	go connection.ReadLoop()
+	go connection.WriteLoop()
+	go connection.HeartbeatLoop()
+	return &connection
+}
+
+//ReadLoop function decodes frames into Incoming until the connection fails or is closed
+func (connection *Connection) ReadLoop() {
+	defer close(connection.Incoming)
+	var sequence Integer
+	for {
+		connection.Conn.SetReadDeadline(time.Now().Add(DeadPeerTimeout))
+		envelope, err := ReadFrame(connection.Conn, connection.frameSize)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				err = errors.New("the peer stopped answering")
+			}
+			connection.Fail(err)
+			return
+		}
+		if connection.limiter != nil && !connection.limiter.Allow() {
+			connection.Fail(errors.New("the peer sent too many messages"))
+			return
+		}
+		sequence++
+		if envelope.Sequence != sequence {
+			connection.Fail(fmt.Errorf("expected message %d, received %d", sequence, envelope.Sequence))
+			return
+		}
+		message, err := DecodeMessage(envelope)
+		if err != nil {
+			connection.Fail(err)
+			return
+		}
+		switch message := message.(type) {
+		case *PingMessage:
+			select {
+			case connection.outgoing <- &PongMessage{SentAt: message.SentAt}:
+			default:
+			}
+			continue
+		case *PongMessage:
+			connection.errMutex.Lock()
+			connection.rtt = time.Since(time.Unix(0, message.SentAt))
+			connection.errMutex.Unlock()
+			continue
+		}
+		select {
+		case connection.Incoming <- message:
+		case <-connection.done:
+			return
+		}
+	}
+}
+
+//WriteLoop function numbers and writes the queued messages until the connection fails or is closed
+func (connection *Connection) WriteLoop() {
+	var sequence Integer
+	for {
+		select {
+		case message := <-connection.outgoing:
+			if closing, ok := message.(*closeMessage); ok {
+				connection.Fail(closing.err)
+				return
+			}
+			sequence++
+			envelope, err := EncodeMessage(sequence, message)
+			if err == nil {
+				connection.Conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+				err = WriteFrame(connection.Conn, envelope)
+			}
+			if err != nil {
+				connection.Fail(err)
+				return
+			}
+		case <-connection.done:
+			return
+		}
+	}
+}
+
+//HeartbeatLoop function sends a ping every HeartbeatInterval until the connection is closed
+func (connection *Connection) HeartbeatLoop() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		connection.Send(&PingMessage{SentAt: time.Now().UnixNano()})
+		select {
+		case <-ticker.C:
+		case <-connection.done:
+			return
+		}
+	}
+}
+
+//RTT function returns the last measured round trip time, 0 until the first pong arrives
+func (connection *Connection) RTT() time.Duration {
+	connection.errMutex.Lock()
+	defer connection.errMutex.Unlock()
+	return connection.rtt
+}
+
+//Closed function reports whether the connection has ended
+func (connection *Connection) Closed() bool {
+	select {
+	case <-connection.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//QualityString function describes the state of the connection for the status indicator
+func (connection *Connection) QualityString() string {
+	rtt := connection.RTT()
+	switch {
+	case connection.Closed():
+		return "disconnected"
+	case rtt == 0:
+		return "connecting"
+	case rtt < 100*time.Millisecond:
+		return fmt.Sprintf("▮▮▮ %dms", rtt.Milliseconds())
+	case rtt < 300*time.Millisecond:
+		return fmt.Sprintf("▮▮▯ %dms", rtt.Milliseconds())
+	}
+	return fmt.Sprintf("▮▯▯ %dms", rtt.Milliseconds())
+}
+
+//Send function queues a message for the writer goroutine
+func (connection *Connection) Send(message Message) error {
+	select {
+	case <-connection.done:
+		return connection.Err()
+	default:
+	}
+	select {
+	case connection.outgoing <- message:
+		return nil
+	case <-connection.done:
+		return connection.Err()
+	}
+}
+
+//closeMessage structure is queued after the last message of a rejected peer, WriteLoop closes the connection when it gets there
+type closeMessage struct {
+	err error
+}
+
+//MessageType function
+func (message *closeMessage) MessageType() string {
+	return "close"
+}
+
+//Reject function sends reason to the peer and closes the connection once it was written
+func (connection *Connection) Reject(reason string) {
+	connection.Send(&ErrorMessage{Reason: reason})
+	connection.Send(&closeMessage{err: errors.New(reason)})
+}
+
+//Drain function closes the connection once the messages queued before were written, or after timeout
+func (connection *Connection) Drain(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case connection.outgoing <- &closeMessage{err: errors.New("connection closed")}:
+		select {
+		case <-connection.done:
+		case <-timer.C:
+		}
+	case <-connection.done:
+	case <-timer.C:
+	}
+	connection.Close()
+}
+
+//Fail function records the first error and closes the connection
+func (connection *Connection) Fail(err error) {
+	connection.setErr(err)
+	connection.Close()
+}
+
+func (connection *Connection) setErr(err error) {
+	connection.errMutex.Lock()
+	if connection.err == nil {
+		connection.err = err
+	}
+	connection.errMutex.Unlock()
+}
+
+//Err function returns the reason the connection ended
+func (connection *Connection) Err() error {
+	connection.errMutex.Lock()
+	defer connection.errMutex.Unlock()
+	return connection.err
+}
+
+//Close function stops both goroutines and closes the underlying net.Conn
+func (connection *Connection) Close() error {
+	var err error
+	connection.closeOnce.Do(func() {
+		connection.setErr(errors.New("connection closed"))
+		close(connection.done)
+		err = connection.Conn.Close()
+	})
+	return err
+}
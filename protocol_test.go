@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+//testFrame function returns the frame of a message as a client sends it
+func testFrame(t testing.TB, message Message) []byte {
+	envelope, err := EncodeMessage(1, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err := WriteFrame(&buffer, envelope); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+//testRawFrame function returns a frame whose header declares size bytes, whatever the length of body is
+func testRawFrame(size uint32, body string) []byte {
+	frame := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(frame, size)
+	return append(frame, body...)
+}
+
+//FuzzDecode reads frames as the lobby reads the ones of its clients: ReadFrame, DecodeMessage and Validate
+//must reject anything without panicking, and a message they accept must survive being sent again
+func FuzzDecode(f *testing.F) {
+	for _, message := range []Message{
+		&HelloMessage{ProtocolVersion: ProtocolVersion, BuildVersion: BuildVersion, Features: SupportedFeatures},
+		&StartingHandMessage{Cards: []Integer{0, 1, 2, 3}},
+		&StartingHandMessage{Cards: []Integer{-1, 1 << 30}},
+		&DeployMessage{HandIndex: 0, Row: 1, Column: 3},
+		&DeployMessage{HandIndex: -1, Row: BoardRows, Column: -5},
+		&DraftPickMessage{Index: DraftPackSize},
+		&ReadyMessage{},
+		&CreateRoomMessage{Name: "duel", Password: "secret", Draft: true},
+		&CreateRoomMessage{Name: "ranked-1"},
+		&JoinRoomMessage{Name: "duel"},
+		&ResumeMessage{Token: "0123456789abcdef0123456789abcdef"},
+		&SetNameMessage{Name: "Ana", Secret: "not hex"},
+		&ChatMessage{Text: "hola"},
+		&TournamentMessage{Action: "create", Name: "cup", Format: "swiss"},
+		&PingMessage{SentAt: 1},
+	} {
+		f.Add(testFrame(f, message))
+	}
+	for _, body := range []string{
+		`{}`,
+		`null`,
+		`{"Type":"deploy","Sequence":1,"Payload":1}`,
+		`{"Type":"deploy","Sequence":1,"Payload":{"HandIndex":1e99}}`,
+		`{"Type":"starting-hand","Sequence":1,"Payload":{"Cards":null}}`,
+		`{"Type":"unknown","Sequence":1,"Payload":{}}`,
+		`{"Type":"chat","Sequence":1,"Payload":{"Text":"\u0000\ud800"}}`,
+	} {
+		f.Add(testRawFrame(uint32(len(body)), body))
+	}
+	f.Add(testRawFrame(MaximumClientFrameSize+1, ""))
+	f.Add(testRawFrame(10, "{}"))
+	f.Add([]byte{0, 0})
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		envelope, err := ReadFrame(bytes.NewReader(frame), MaximumClientFrameSize)
+		if err != nil {
+			return
+		}
+		message, err := DecodeMessage(envelope)
+		if err != nil {
+			return
+		}
+		if validator, ok := message.(Validator); ok && validator.Validate() != nil {
+			return
+		}
+		again, err := ReadFrame(bytes.NewReader(testFrame(t, message)), MaximumFrameSize)
+		if err != nil {
+			t.Fatalf("%s message accepted but not sent again: %v", message.MessageType(), err)
+		}
+		if _, err := DecodeMessage(again); err != nil {
+			t.Fatalf("%s message accepted but not decoded again: %v", message.MessageType(), err)
+		}
+	})
+}
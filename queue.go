@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+//RatingsFile stores the Elo rating of every player name on the server
+const RatingsFile = "ratings.json"
+
+//Parametros del emparejamiento: rating inicial, factor K de Elo, ventana de rating permitida y cuanto crece con la espera
+const (
+	StartingRating      = 1500.0
+	EloFactor           = 32.0
+	InitialRatingWindow = 100.0
+	RatingWindowGrowth  = 50.0
+	RatingWindowStep    = 10 * time.Second
+	MatchmakingInterval = time.Second
+	ConfirmTimeout      = 20 * time.Second
+)
+
+//QueueMessage structure asks the server to put the client in the matchmaking queue
+type QueueMessage struct {
+}
+
+//MessageType function
+func (message *QueueMessage) MessageType() string {
+	return "queue"
+}
+
+//LeaveQueueMessage structure takes the client out of the matchmaking queue
+type LeaveQueueMessage struct {
+}
+
+//MessageType function
+func (message *LeaveQueueMessage) MessageType() string {
+	return "leave-queue"
+}
+
+//QueuedMessage structure tells a client that it is waiting in the queue, Reason explains why if it was put back
+type QueuedMessage struct {
+	Rating Integer
+	Reason string
+}
+
+//MessageType function
+func (message *QueuedMessage) MessageType() string {
+	return "queued"
+}
+
+//MatchFoundMessage structure proposes an opponent, the client has ConfirmTimeout to confirm it
+type MatchFoundMessage struct {
+	Opponent       string
+	OpponentRating Integer
+	Rating         Integer
+}
+
+//MessageType function
+func (message *MatchFoundMessage) MessageType() string {
+	return "match-found"
+}
+
+//ConfirmMatchMessage structure accepts or declines the proposed opponent
+type ConfirmMatchMessage struct {
+	Accept bool
+}
+
+//MessageType function
+func (message *ConfirmMatchMessage) MessageType() string {
+	return "confirm-match"
+}
+
+//RatingChangedMessage structure tells a client its rating after a ranked match
+type RatingChangedMessage struct {
+	Rating Integer
+	Change Integer
+}
+
+//MessageType function
+func (message *RatingChangedMessage) MessageType() string {
+	return "rating-changed"
+}
+
+//Ratings structure is the Elo rating of every player name, saved to File after every change
+type Ratings struct {
+	File   string
+	Mutex  sync.Mutex
+	Values map[string]float64
+}
+
+//LoadRatings function reads the ratings of file, a missing file has no ratings
+func LoadRatings(file string) *Ratings {
+	ratings := &Ratings{File: file, Values: make(map[string]float64)}
+	bytes, err := ioutil.ReadFile(file)
+	if err == nil {
+		json.Unmarshal(bytes, &ratings.Values)
+	}
+	return ratings
+}
+
+//Get function returns the rating of a player, StartingRating if it never played a ranked match
+func (ratings *Ratings) Get(name string) float64 {
+	ratings.Mutex.Lock()
+	defer ratings.Mutex.Unlock()
+	rating, ok := ratings.Values[name]
+	if !ok {
+		return StartingRating
+	}
+	return rating
+}
+
+//Record function updates the ratings of two players with Elo and saves them.
+//score is the result of a: 1 if it won, 0.5 for a draw and 0 if it lost
+func (ratings *Ratings) Record(a, b string, score float64) (float64, float64, error) {
+	ratings.Mutex.Lock()
+	defer ratings.Mutex.Unlock()
+	ratingA, ratingB := StartingRating, StartingRating
+	if rating, ok := ratings.Values[a]; ok {
+		ratingA = rating
+	}
+	if rating, ok := ratings.Values[b]; ok {
+		ratingB = rating
+	}
+	expected := 1 / (1 + math.Pow(10, (ratingB-ratingA)/400))
+	change := EloFactor * (score - expected)
+	ratings.Values[a] = ratingA + change
+	ratings.Values[b] = ratingB - change
+	return ratings.Values[a], ratings.Values[b], ioutil.WriteFile(ratings.File, []byte(StructToJSONPretty(ratings.Values)), 0644)
+}
+
+//QueueEntry structure is a client waiting in the matchmaking queue since Since
+type QueueEntry struct {
+	Client *Client
+	Rating float64
+	Since  time.Time
+}
+
+//RatingWindow function returns how far the rating of an opponent may be, it widens the longer the entry waits
+func (entry *QueueEntry) RatingWindow(now time.Time) float64 {
+	return InitialRatingWindow + RatingWindowGrowth*float64(now.Sub(entry.Since)/RatingWindowStep)
+}
+
+//Proposal structure pairs two queue entries until both confirm or one declines
+type Proposal struct {
+	Entries  [2]*QueueEntry
+	Accepted [2]bool
+	Deadline time.Time
+}
+
+//Queue function puts a client in the matchmaking queue
+func (lobby *Lobby) Queue(client *Client) error {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if !client.Connection.HasFeature(MatchmakingFeature) {
+		return errors.New("your build does not support matchmaking")
+	}
+	if !client.Named {
+		return errors.New("choose a name with /name before queueing, ratings are stored by name")
+	}
+	if client.Room != nil {
+		return errors.New("you are already in room " + client.Room.Name)
+	}
+	if client.Queued {
+		return errors.New("you are already in the queue")
+	}
+	for _, entry := range lobby.MatchQueue {
+		if entry.Client.Name == client.Name {
+			return errors.New("a player named " + client.Name + " is already in the queue")
+		}
+	}
+	entry := &QueueEntry{Client: client, Rating: lobby.Ratings.Get(client.Name), Since: time.Now()}
+	lobby.requeue(entry, "")
+	lobby.Log(client.Name + " joined the matchmaking queue")
+	return nil
+}
+
+//requeue function puts an entry back in the queue keeping its waiting time, the lobby mutex must be held
+func (lobby *Lobby) requeue(entry *QueueEntry, reason string) {
+	entry.Client.Queued = true
+	entry.Client.Proposal = nil
+	lobby.MatchQueue = append(lobby.MatchQueue, entry)
+	entry.Client.Connection.Send(&QueuedMessage{Rating: Integer(math.Round(entry.Rating)), Reason: reason})
+}
+
+//LeaveQueue function takes a client out of the queue, declining its proposal if it has one
+func (lobby *Lobby) LeaveQueue(client *Client) {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if client.Proposal != nil {
+		lobby.decline(client)
+		return
+	}
+	for index, entry := range lobby.MatchQueue {
+		if entry.Client == client {
+			lobby.MatchQueue = append(lobby.MatchQueue[:index], lobby.MatchQueue[index+1:]...)
+			break
+		}
+	}
+	client.Queued = false
+}
+
+//ConfirmMatch function records the answer of a client to its proposal and starts the match once both accepted
+func (lobby *Lobby) ConfirmMatch(client *Client, accept bool) error {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	proposal := client.Proposal
+	if proposal == nil {
+		return errors.New("there is no match to confirm")
+	}
+	if !accept {
+		lobby.decline(client)
+		return nil
+	}
+	for index, entry := range proposal.Entries {
+		if entry.Client == client {
+			proposal.Accepted[index] = true
+		}
+	}
+	if !proposal.Accepted[0] || !proposal.Accepted[1] {
+		return nil
+	}
+	room := &Room{Name: "ranked-" + NewSessionToken()[:8], Ranked: true, Inbox: make(chan RoomMessage), done: make(chan struct{})}
+	lobby.Rooms[room.Name] = room
+	for _, entry := range proposal.Entries {
+		entry.Client.Queued = false
+		entry.Client.Proposal = nil
+		lobby.seat(entry.Client, room)
+	}
+	lobby.startRoom(room)
+	lobby.Log("Ranked match " + room.Name + " between " + room.Names[0] + " and " + room.Names[1])
+	return nil
+}
+
+//decline function cancels the proposal of a client, its opponent goes back to the queue. The lobby mutex must be held
+func (lobby *Lobby) decline(client *Client) {
+	proposal := client.Proposal
+	for index, entry := range proposal.Entries {
+		proposal.Accepted[index] = entry.Client != client
+	}
+	lobby.cancelProposal(proposal, "your opponent declined the match")
+}
+
+//cancelProposal function ends a proposal: the players that accepted go back to the queue,
+//the others leave it. The lobby mutex must be held
+func (lobby *Lobby) cancelProposal(proposal *Proposal, reason string) {
+	for index, entry := range proposal.Entries {
+		if proposal.Accepted[index] {
+			lobby.requeue(entry, reason)
+		} else {
+			entry.Client.Queued = false
+			entry.Client.Proposal = nil
+			entry.Client.Connection.Send(&ErrorMessage{Reason: "you did not accept the match, you left the matchmaking queue"})
+		}
+	}
+}
+
+//Matchmake function pairs the entries of the queue whose ratings are within both of their windows,
+//oldest entries first, and cancels the proposals that were not confirmed in time. The lobby mutex must be held
+func (lobby *Lobby) Matchmake(now time.Time) {
+	for client := range lobby.Clients {
+		if client.Proposal != nil && now.After(client.Proposal.Deadline) {
+			lobby.cancelProposal(client.Proposal, "your opponent did not confirm in time")
+		}
+	}
+	sort.SliceStable(lobby.MatchQueue, func(i, j int) bool {
+		return lobby.MatchQueue[i].Since.Before(lobby.MatchQueue[j].Since)
+	})
+	var waiting []*QueueEntry
+	paired := make(map[*QueueEntry]bool)
+	for i, entry := range lobby.MatchQueue {
+		if paired[entry] {
+			continue
+		}
+		var best *QueueEntry
+		for _, other := range lobby.MatchQueue[i+1:] {
+			distance := math.Abs(entry.Rating - other.Rating)
+			if paired[other] || distance > entry.RatingWindow(now) || distance > other.RatingWindow(now) {
+				continue
+			}
+			if best == nil || distance < math.Abs(entry.Rating-best.Rating) {
+				best = other
+			}
+		}
+		if best == nil {
+			waiting = append(waiting, entry)
+			continue
+		}
+		paired[entry], paired[best] = true, true
+		proposal := &Proposal{Entries: [2]*QueueEntry{entry, best}, Deadline: now.Add(ConfirmTimeout)}
+		for index, proposed := range proposal.Entries {
+			opponent := proposal.Entries[1-index]
+			proposed.Client.Proposal = proposal
+			proposed.Client.Connection.Send(&MatchFoundMessage{
+				Opponent:       opponent.Client.Name,
+				OpponentRating: Integer(math.Round(opponent.Rating)),
+				Rating:         Integer(math.Round(proposed.Rating)),
+			})
+		}
+	}
+	lobby.MatchQueue = waiting
+}
+
+//RunMatchmaking function runs Matchmake every MatchmakingInterval until the lobby stops serving
+func (lobby *Lobby) RunMatchmaking() {
+	ticker := time.NewTicker(MatchmakingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			lobby.Mutex.Lock()
+			lobby.Matchmake(now)
+			lobby.Mutex.Unlock()
+		case <-lobby.Done:
+			return
+		}
+	}
+}
+
+//RecordResult function updates the ratings of the players of a ranked room, winner is -1 for a draw
+func (lobby *Lobby) RecordResult(room *Room, winner Integer) {
+	score := 0.5
+	if winner == 0 {
+		score = 1
+	} else if winner == 1 {
+		score = 0
+	}
+	before := [2]float64{lobby.Ratings.Get(room.Names[0]), lobby.Ratings.Get(room.Names[1])}
+	ratingA, ratingB, err := lobby.Ratings.Record(room.Names[0], room.Names[1], score)
+	if err != nil {
+		lobby.Log(fmt.Sprint("Could not save the ratings: ", err))
+	}
+	after := [2]float64{ratingA, ratingB}
+	for seat, client := range room.Clients {
+		if client != nil {
+			client.Connection.Send(&RatingChangedMessage{
+				Rating: Integer(math.Round(after[seat])),
+				Change: Integer(math.Round(after[seat] - before[seat])),
+			})
+		}
+	}
+	lobby.Log(fmt.Sprintf("Ratings of room %s: %s %.0f, %s %.0f", room.Name, room.Names[0], ratingA, room.Names[1], ratingB))
+}
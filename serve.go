@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+//DefaultServerPort is used by the serve mode when neither -addr nor PORT are given
+const DefaultServerPort = 2048
+
+//LogEntry structure is one line of the structured log of the serve mode
+type LogEntry struct {
+	Time    string
+	Level   string
+	Message string
+}
+
+//WriteLog function writes a structured log line to stdout
+func WriteLog(level, message string) {
+	fmt.Println(StructToJSON(LogEntry{Time: time.Now().UTC().Format(time.RFC3339), Level: level, Message: message}))
+}
+
+//ServeAddress function picks the listen address of the serve mode: the flag, then the PORT environment variable
+func ServeAddress(flagAddress string) string {
+	if flagAddress != "" {
+		return flagAddress
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":" + strconv.Itoa(DefaultServerPort)
+}
+
+//Serve function runs the lobby without a terminal UI until it receives SIGINT or SIGTERM
+func Serve(arguments []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	address := flags.String("addr", "", "listen address, by default :$PORT or :"+strconv.Itoa(DefaultServerPort))
+	announce := flags.Bool("announce", false, "announce the lobby on the LAN")
+	useTLS := flags.Bool("tls", false, "encrypt connections with TLS")
+	certificateFile := flags.String("cert", "server.crt", "TLS certificate, created self-signed if it does not exist")
+	keyFile := flags.String("key", "server.key", "TLS private key, created with the certificate")
+	status := flags.Bool("status", false, "serve "+StatusPath+" and "+MetricsPath+" over HTTP on the lobby port")
+	adminToken := flags.String("admin-token", os.Getenv("ADMIN_TOKEN"), "token of the admin actions, by default $ADMIN_TOKEN, empty disables them")
+	flags.Parse(arguments)
+
+	listener, err := net.Listen("tcp", ServeAddress(*address))
+	if err != nil {
+		WriteLog("error", fmt.Sprint("Server error: ", err))
+		return err
+	}
+	tcpPort := Integer(listener.Addr().(*net.TCPAddr).Port)
+	schemes := []string{"tcp://", "ws://"}
+	if *useTLS {
+		certificate, err := LoadOrCreateCertificate(*certificateFile, *keyFile)
+		if err != nil {
+			WriteLog("error", fmt.Sprint("TLS error: ", err))
+			listener.Close()
+			return err
+		}
+		WriteLog("info", "TLS certificate fingerprint "+CertificateFingerprint(certificate.Certificate[0]))
+		listener = tls.NewListener(listener, ServerTLSConfig(certificate))
+		schemes = []string{"tls://", "wss://"}
+	}
+	lobby := NewLobby(listener, func(message string) {
+		WriteLog("info", message)
+	})
+	WriteLog("info", "Lobby server at "+schemes[0]+listener.Addr().String()+" and "+schemes[1]+listener.Addr().String()+WebSocketPath)
+	if *status || *adminToken != "" {
+		lobby.ServeAdmin(*adminToken)
+		WriteLog("info", "Status at "+StatusPath+" and metrics at "+MetricsPath)
+	}
+	if *announce {
+		go lobby.Announce(tcpPort, schemes[0])
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		received := <-signals
+		WriteLog("info", "Received "+received.String()+", shutting down")
+		lobby.Shutdown()
+		close(stopped)
+	}()
+
+	err = lobby.Serve()
+	if lobby.ShuttingDown() {
+		<-stopped
+		WriteLog("info", "Lobby server stopped")
+		return nil
+	}
+	WriteLog("error", fmt.Sprint("tcp server accept error: ", err))
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+//KnownServersFile stores the certificate fingerprint of every TLS server the client trusted
+var KnownServersFile = "known_servers.json"
+
+//knownServersMutex serializes the reads and writes of KnownServersFile
+var knownServersMutex sync.Mutex
+
+//LoadOrCreateCertificate function loads the certificate and key files, creating a self-signed pair on the first run
+func LoadOrCreateCertificate(certificateFile, keyFile string) (tls.Certificate, error) {
+	if _, err := os.Stat(certificateFile); os.IsNotExist(err) {
+		if err := CreateCertificate(certificateFile, keyFile); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.LoadX509KeyPair(certificateFile, keyFile)
+}
+
+//CreateCertificate function writes a new self-signed ECDSA certificate and its key as PEM files
+func CreateCertificate(certificateFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "GameTheGame"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certificateFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+}
+
+//CertificateFingerprint function returns the SHA-256 of a DER certificate in hexadecimal
+func CertificateFingerprint(certificate []byte) string {
+	sum := sha256.Sum256(certificate)
+	return hex.EncodeToString(sum[:])
+}
+
+//ServerTLSConfig function returns the TLS configuration of a server using certificate
+func ServerTLSConfig(certificate tls.Certificate) *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+}
+
+//ClientTLSConfig function returns a TLS configuration that pins the certificate of the server at address.
+//The first connection trusts and stores the fingerprint, later ones fail if it changed.
+//onNewServer is called with the fingerprint the first time a server is trusted
+func ClientTLSConfig(address string, onNewServer func(fingerprint string)) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+		VerifyPeerCertificate: func(certificates [][]byte, _ [][]*x509.Certificate) error {
+			if len(certificates) == 0 {
+				return errors.New("the server sent no certificate")
+			}
+			fingerprint := CertificateFingerprint(certificates[0])
+			knownServersMutex.Lock()
+			defer knownServersMutex.Unlock()
+			knownServers := LoadKnownServers()
+			known, ok := knownServers[address]
+			if !ok {
+				knownServers[address] = fingerprint
+				if onNewServer != nil {
+					onNewServer(fingerprint)
+				}
+				return SaveKnownServers(knownServers)
+			}
+			if known != fingerprint {
+				return errors.New("the certificate of " + address + " changed, its fingerprint is now " + fingerprint +
+					", remove it from " + KnownServersFile + " if you trust it")
+			}
+			return nil
+		},
+	}
+}
+
+//LoadKnownServers function reads KnownServersFile, a missing file has no servers
+func LoadKnownServers() map[string]string {
+	knownServers := make(map[string]string)
+	bytes, err := ioutil.ReadFile(KnownServersFile)
+	if err == nil {
+		json.Unmarshal(bytes, &knownServers)
+	}
+	return knownServers
+}
+
+//SaveKnownServers function writes KnownServersFile
+func SaveKnownServers(knownServers map[string]string) error {
+	return ioutil.WriteFile(KnownServersFile, []byte(StructToJSONPretty(knownServers)), 0644)
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Formatos de torneo
+const (
+	SingleElimination Integer = iota
+	DoubleElimination
+	SwissTournament
+)
+
+//TournamentFormats maps the name used by the tournament command to its format
+var TournamentFormats = map[string]Integer{
+	"single": SingleElimination,
+	"double": DoubleElimination,
+	"swiss":  SwissTournament,
+}
+
+//TournamentFormatNames describes every format in the bracket
+var TournamentFormatNames = map[Integer]string{
+	SingleElimination: "single elimination",
+	DoubleElimination: "double elimination",
+	SwissTournament:   "Swiss",
+}
+
+//Limites de los torneos: jugadores por torneo, torneos abiertos por creador, torneos en la lista
+//y cuanto sigue en el lobby un torneo terminado
+const (
+	MaximumTournamentPlayers   = 64
+	MaximumOpenTournaments     = 2
+	MaximumListedTournaments   = 50
+	FinishedTournamentLifetime = 10 * time.Minute
+)
+
+//BotName is the opponent of a bye, it forfeits its match
+const BotName = "Bot"
+
+//TournamentMessage structure is a tournament command of a client: list, create, join, start or show.
+//Format is only used by create
+type TournamentMessage struct {
+	Action string
+	Name   string
+	Format string
+}
+
+//MessageType function
+func (message *TournamentMessage) MessageType() string {
+	return "tournament"
+}
+
+//BracketMessage structure carries tournaments drawn as text trees
+type BracketMessage struct {
+	Text string
+}
+
+//MessageType function
+func (message *BracketMessage) MessageType() string {
+	return "bracket"
+}
+
+//Pairing structure is a match of a tournament. Winner is the index in Players of the winner, -1 while it is played.
+//Bracket tells winners, losers and final apart in double elimination. A Draw of an elimination format is decided by a CoinToss
+type Pairing struct {
+	Players  [2]string
+	Bracket  string
+	Winner   Integer
+	Draw     bool
+	CoinToss bool
+	Room     string
+}
+
+//Tournament structure is a tournament of the lobby. Players play one pairing per round,
+//Losses decide elimination and Points the Swiss standings
+type Tournament struct {
+	Name     string
+	Format   Integer
+	Owner    string
+	Players  []string
+	Rounds   [][]*Pairing
+	Losses   map[string]Integer
+	Points   map[string]float64
+	Byes     map[string]bool
+	Rematch  map[string]bool
+	Started  bool
+	Champion string
+	random   *rand.Rand
+}
+
+//NewTournament function creates a tournament that owner can start once players joined
+func NewTournament(name string, format Integer, owner string, seed int64) *Tournament {
+	return &Tournament{
+		Name:    name,
+		Format:  format,
+		Owner:   owner,
+		Losses:  make(map[string]Integer),
+		Points:  make(map[string]float64),
+		Byes:    make(map[string]bool),
+		Rematch: make(map[string]bool),
+		random:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+//Join function adds a player before the tournament starts
+func (tournament *Tournament) Join(player string) error {
+	if tournament.Started {
+		return errors.New("tournament " + tournament.Name + " already started")
+	}
+	if player == BotName {
+		return errors.New(BotName + " is reserved for the opponent of byes, choose another name")
+	}
+	if len(tournament.Players) >= MaximumTournamentPlayers {
+		return errors.New("tournament " + tournament.Name + " is full")
+	}
+	for _, joined := range tournament.Players {
+		if joined == player {
+			return errors.New("you already joined tournament " + tournament.Name)
+		}
+	}
+	tournament.Players = append(tournament.Players, player)
+	return nil
+}
+
+//Start function shuffles the players and pairs the first round
+func (tournament *Tournament) Start() ([]*Pairing, error) {
+	if tournament.Started {
+		return nil, errors.New("tournament " + tournament.Name + " already started")
+	}
+	if len(tournament.Players) < 2 {
+		return nil, errors.New("a tournament needs at least 2 players")
+	}
+	tournament.Started = true
+	tournament.random.Shuffle(len(tournament.Players), func(i, j int) {
+		tournament.Players[i], tournament.Players[j] = tournament.Players[j], tournament.Players[i]
+	})
+	return tournament.NextRound(), nil
+}
+
+//SwissRounds function returns the number of rounds of a Swiss tournament, enough to leave a single undefeated player
+func (tournament *Tournament) SwissRounds() Integer {
+	rounds := Integer(0)
+	for 1<<uint(rounds) < len(tournament.Players) {
+		rounds++
+	}
+	return rounds
+}
+
+//Alive function lists the players that are not eliminated, in their seeding order
+func (tournament *Tournament) Alive() []string {
+	var alive []string
+	for _, player := range tournament.Players {
+		if tournament.Format == SwissTournament ||
+			(tournament.Format == SingleElimination && tournament.Losses[player] == 0) ||
+			(tournament.Format == DoubleElimination && tournament.Losses[player] < 2) {
+			alive = append(alive, player)
+		}
+	}
+	return alive
+}
+
+//RoundFinished function reports whether every pairing of the current round has a winner
+func (tournament *Tournament) RoundFinished() bool {
+	if len(tournament.Rounds) == 0 {
+		return false
+	}
+	for _, pairing := range tournament.Rounds[len(tournament.Rounds)-1] {
+		if pairing.Winner < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//Finished function reports whether the tournament has a champion
+func (tournament *Tournament) Finished() bool {
+	return tournament.Champion != ""
+}
+
+//NextRound function pairs the next round, or sets the champion if the tournament is over.
+//Byes are played against BotName and won at once
+func (tournament *Tournament) NextRound() []*Pairing {
+	alive := tournament.Alive()
+	if tournament.Format == SwissTournament && Integer(len(tournament.Rounds)) >= tournament.SwissRounds() {
+		tournament.Champion = tournament.Standings()[0]
+		return nil
+	}
+	if tournament.Format != SwissTournament && len(alive) == 1 {
+		tournament.Champion = alive[0]
+		return nil
+	}
+	var pairings []*Pairing
+	switch tournament.Format {
+	case SingleElimination:
+		pairings = tournament.pairInOrder(alive, "")
+	case DoubleElimination:
+		var winners, losers []string
+		for _, player := range alive {
+			if tournament.Losses[player] == 0 {
+				winners = append(winners, player)
+			} else {
+				losers = append(losers, player)
+			}
+		}
+		if len(alive) == 2 {
+			pairings = []*Pairing{{Players: [2]string{alive[0], alive[1]}, Bracket: "final", Winner: -1}}
+		} else {
+			pairings = append(tournament.pairInOrder(winners, "winners"), tournament.pairInOrder(losers, "losers")...)
+		}
+	case SwissTournament:
+		pairings = tournament.pairSwiss()
+	}
+	tournament.Rounds = append(tournament.Rounds, pairings)
+	for _, pairing := range pairings {
+		if pairing.Players[1] == BotName {
+			tournament.Record(pairing, 0)
+		}
+	}
+	return pairings
+}
+
+//pairInOrder function pairs the first player with the last one, the second with the one before the last and so on,
+//the middle player of an odd count gets a bye
+func (tournament *Tournament) pairInOrder(players []string, bracket string) []*Pairing {
+	var pairings []*Pairing
+	for first, last := 0, len(players)-1; first <= last; first, last = first+1, last-1 {
+		opponent := BotName
+		if first != last {
+			opponent = players[last]
+		}
+		pairings = append(pairings, &Pairing{Players: [2]string{players[first], opponent}, Bracket: bracket, Winner: -1})
+	}
+	return pairings
+}
+
+//MaximumSwissPairingSteps bounds the search of a Swiss round without rematches, the round falls back to greedy pairing after it
+const MaximumSwissPairingSteps = 100000
+
+//pairSwiss function pairs players with close points that did not play each other yet,
+//the lowest ranked player without a bye gets one if the count is odd
+func (tournament *Tournament) pairSwiss() []*Pairing {
+	standings := tournament.Standings()
+	var pairings []*Pairing
+	if len(standings)%2 == 1 {
+		bye := len(standings) - 1
+		for index := len(standings) - 1; index >= 0; index-- {
+			if !tournament.Byes[standings[index]] {
+				bye = index
+				break
+			}
+		}
+		tournament.Byes[standings[bye]] = true
+		pairings = append(pairings, &Pairing{Players: [2]string{standings[bye], BotName}, Winner: -1})
+		standings = append(standings[:bye:bye], standings[bye+1:]...)
+	}
+	steps := MaximumSwissPairingSteps
+	pairs := tournament.pairWithoutRematches(standings, &steps)
+	if pairs == nil {
+		pairs = tournament.pairGreedily(standings)
+	}
+	for _, pair := range pairs {
+		tournament.Rematch[pair[0]+"\n"+pair[1]], tournament.Rematch[pair[1]+"\n"+pair[0]] = true, true
+		pairings = append(pairings, &Pairing{Players: pair, Winner: -1})
+	}
+	return pairings
+}
+
+//pairWithoutRematches function pairs the first player with the closest one in the standings it did not meet,
+//and the rest the same way, going back when the last players could only meet again.
+//It returns nil if there is no such pairing or it was not found within steps
+func (tournament *Tournament) pairWithoutRematches(players []string, steps *int) [][2]string {
+	if len(players) == 0 {
+		return [][2]string{}
+	}
+	for index := 1; index < len(players); index++ {
+		*steps--
+		if *steps < 0 {
+			return nil
+		}
+		if tournament.Rematch[players[0]+"\n"+players[index]] {
+			continue
+		}
+		rest := append(append([]string(nil), players[1:index]...), players[index+1:]...)
+		if pairs := tournament.pairWithoutRematches(rest, steps); pairs != nil {
+			return append([][2]string{{players[0], players[index]}}, pairs...)
+		}
+	}
+	return nil
+}
+
+//pairGreedily function pairs every player with the closest one it did not meet, or with the closest one if it met them all
+func (tournament *Tournament) pairGreedily(players []string) [][2]string {
+	var pairs [][2]string
+	paired := make(map[string]bool)
+	for index, player := range players {
+		if paired[player] {
+			continue
+		}
+		opponent := ""
+		for _, other := range players[index+1:] {
+			if paired[other] {
+				continue
+			}
+			if opponent == "" {
+				opponent = other
+			}
+			if !tournament.Rematch[player+"\n"+other] {
+				opponent = other
+				break
+			}
+		}
+		paired[player], paired[opponent] = true, true
+		pairs = append(pairs, [2]string{player, opponent})
+	}
+	return pairs
+}
+
+//Standings function sorts the players by points, then by seeding order
+func (tournament *Tournament) Standings() []string {
+	standings := append([]string(nil), tournament.Players...)
+	sort.SliceStable(standings, func(i, j int) bool {
+		return tournament.Points[standings[i]] > tournament.Points[standings[j]]
+	})
+	return standings
+}
+
+//Record function stores the result of a pairing, winner is -1 for a draw.
+//Elimination formats need a winner, so a draw is decided by a coin toss
+func (tournament *Tournament) Record(pairing *Pairing, winner Integer) {
+	if winner < 0 && tournament.Format == SwissTournament {
+		pairing.Draw = true
+		pairing.Winner = 0
+		tournament.Points[pairing.Players[0]] += 0.5
+		tournament.Points[pairing.Players[1]] += 0.5
+		return
+	}
+	if winner < 0 {
+		pairing.CoinToss = true
+		winner = Integer(tournament.random.Intn(2))
+	}
+	pairing.Winner = winner
+	tournament.Points[pairing.Players[winner]]++
+	tournament.Losses[pairing.Players[1-winner]]++
+}
+
+//PairingString function describes a pairing, the winner is marked with a check
+func (pairing *Pairing) PairingString() string {
+	names := pairing.Players
+	switch {
+	case pairing.Winner < 0 && pairing.Room != "":
+		return names[0] + " vs " + names[1] + " (playing in " + pairing.Room + ")"
+	case pairing.Winner < 0:
+		return names[0] + " vs " + names[1]
+	case pairing.Draw:
+		return names[0] + " vs " + names[1] + " (draw)"
+	}
+	names[pairing.Winner] += " ✓"
+	if pairing.CoinToss {
+		return names[0] + " vs " + names[1] + " (draw, decided by a coin toss)"
+	}
+	return names[0] + " vs " + names[1]
+}
+
+//SummaryString function describes the tournament in one line
+func (tournament *Tournament) SummaryString() string {
+	state := "waiting to start"
+	if tournament.Finished() {
+		state = "won by " + tournament.Champion
+	} else if tournament.Started {
+		state = "round " + strconv.Itoa(len(tournament.Rounds))
+	}
+	return fmt.Sprintf("%s (%s, %d players, %s)", tournament.Name, TournamentFormatNames[tournament.Format], len(tournament.Players), state)
+}
+
+//BracketString function draws the tournament as a text tree, one branch per round
+func (tournament *Tournament) BracketString() string {
+	lines := []string{fmt.Sprintf("Tournament %s (%s, %d players, created by %s)",
+		tournament.Name, TournamentFormatNames[tournament.Format], len(tournament.Players), tournament.Owner)}
+	if !tournament.Started {
+		lines = append(lines, "└─ Waiting to start: "+strings.Join(tournament.Players, ", "))
+		return strings.Join(lines, "\n")
+	}
+	for round, pairings := range tournament.Rounds {
+		branch, indent := "├─ ", "│  "
+		if round == len(tournament.Rounds)-1 && tournament.Format != SwissTournament && !tournament.Finished() {
+			branch, indent = "└─ ", "   "
+		}
+		lines = append(lines, branch+"Round "+strconv.Itoa(round+1))
+		for index, pairing := range pairings {
+			leaf := "├─ "
+			if index == len(pairings)-1 {
+				leaf = "└─ "
+			}
+			label := ""
+			if pairing.Bracket != "" {
+				label = pairing.Bracket + ": "
+			}
+			lines = append(lines, indent+leaf+label+pairing.PairingString())
+		}
+	}
+	if tournament.Format == SwissTournament {
+		branch, indent := "├─ ", "│  "
+		if !tournament.Finished() {
+			branch, indent = "└─ ", "   "
+		}
+		lines = append(lines, branch+"Standings")
+		for index, player := range tournament.Standings() {
+			lines = append(lines, fmt.Sprintf("%s%d. %s %.1f", indent, index+1, player, tournament.Points[player]))
+		}
+	}
+	if tournament.Finished() {
+		lines = append(lines, "└─ Champion: "+tournament.Champion)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//ParseTournamentCommand function turns the fields of a tournaments or tournament command into its message.
+//It returns nil if the command is malformed
+func ParseTournamentCommand(fields []string) *TournamentMessage {
+	if strings.ToLower(fields[0]) == "tournaments" {
+		if len(fields) != 1 {
+			return nil
+		}
+		return &TournamentMessage{Action: "list"}
+	}
+	if len(fields) < 3 {
+		return nil
+	}
+	message := &TournamentMessage{Action: strings.ToLower(fields[1]), Name: fields[2]}
+	switch {
+	case message.Action == "create" && len(fields) == 4:
+		message.Format = strings.ToLower(fields[3])
+	case (message.Action == "join" || message.Action == "start" || message.Action == "show") && len(fields) == 3:
+	default:
+		return nil
+	}
+	return message
+}
+
+//HandleTournament function runs a tournament command of a client
+func (lobby *Lobby) HandleTournament(client *Client, message *TournamentMessage) error {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	if message.Action != "list" && !client.Named {
+		return errors.New("choose a name with /name before playing tournaments, results are stored by name")
+	}
+	tournament := lobby.Tournaments[message.Name]
+	if message.Action != "list" && message.Action != "create" && tournament == nil {
+		return errors.New("tournament " + message.Name + " does not exist")
+	}
+	switch message.Action {
+	case "list":
+		var summaries []string
+		for _, tournament := range lobby.Tournaments {
+			summaries = append(summaries, tournament.SummaryString())
+		}
+		sort.Strings(summaries)
+		if len(summaries) == 0 {
+			summaries = []string{"There are no tournaments, create one with: tournament create <name> single|double|swiss"}
+		} else if len(summaries) > MaximumListedTournaments {
+			summaries = append(summaries[:MaximumListedTournaments], fmt.Sprintf("and %d more", len(summaries)-MaximumListedTournaments))
+		}
+		client.Connection.Send(&BracketMessage{Text: "Tournaments, type \"tournament show <name>\" to see a bracket:\n" + strings.Join(summaries, "\n")})
+		return nil
+	case "create":
+		if tournament != nil {
+			return errors.New("tournament " + message.Name + " already exists")
+		}
+		open := 0
+		for _, other := range lobby.Tournaments {
+			if other.Owner == client.Name && !other.Finished() {
+				open++
+			}
+		}
+		if open >= MaximumOpenTournaments {
+			return errors.New("you can not have more than " + strconv.Itoa(MaximumOpenTournaments) + " tournaments that did not finish")
+		}
+		format, ok := TournamentFormats[message.Format]
+		if !ok {
+			return errors.New("the format must be single, double or swiss")
+		}
+		tournament = NewTournament(message.Name, format, client.Name, time.Now().UnixNano())
+		lobby.Tournaments[message.Name] = tournament
+		tournament.Join(client.Name)
+		lobby.Log("Tournament " + message.Name + " created by " + client.Name)
+	case "join":
+		if err := tournament.Join(client.Name); err != nil {
+			return err
+		}
+	case "start":
+		if tournament.Owner != client.Name {
+			return errors.New("only " + tournament.Owner + " can start tournament " + tournament.Name)
+		}
+		pairings, err := tournament.Start()
+		if err != nil {
+			return err
+		}
+		lobby.Log("Tournament " + tournament.Name + " started")
+		lobby.startPairings(tournament, pairings)
+		return nil
+	}
+	client.Connection.Send(&BracketMessage{Text: tournament.BracketString()})
+	return nil
+}
+
+//startPairings function seats the players of every pending pairing in a new room. A player that is not connected,
+//or busy in another room or in the queue, forfeits. Rounds advance while they finish at once. The lobby mutex must be held
+func (lobby *Lobby) startPairings(tournament *Tournament, pairings []*Pairing) {
+	for {
+		for round, pairing := range pairings {
+			if pairing.Winner >= 0 {
+				continue
+			}
+			var clients [2]*Client
+			for client := range lobby.Clients {
+				for index, player := range pairing.Players {
+					if client.Named && client.Name == player && client.Room == nil && !client.Queued {
+						clients[index] = client
+					}
+				}
+			}
+			if clients[0] == nil || clients[1] == nil {
+				winner := Integer(0)
+				if clients[0] == nil {
+					winner = 1
+				}
+				tournament.Record(pairing, winner)
+				continue
+			}
+			room := &Room{Name: "tournament-" + tournament.Name + "-" + strconv.Itoa(len(tournament.Rounds)) + "-" + strconv.Itoa(round+1),
+				Tournament: tournament, Pairing: pairing, Inbox: make(chan RoomMessage), done: make(chan struct{})}
+			pairing.Room = room.Name
+			lobby.Rooms[room.Name] = room
+			for _, client := range clients {
+				lobby.seat(client, room)
+			}
+			lobby.startRoom(room)
+		}
+		lobby.sendBracket(tournament)
+		if !tournament.RoundFinished() {
+			return
+		}
+		pairings = tournament.NextRound()
+		if tournament.Finished() {
+			lobby.sendBracket(tournament)
+			lobby.Log("Tournament " + tournament.Name + " won by " + tournament.Champion)
+			time.AfterFunc(FinishedTournamentLifetime, func() {
+				lobby.Mutex.Lock()
+				defer lobby.Mutex.Unlock()
+				if lobby.Tournaments[tournament.Name] == tournament {
+					delete(lobby.Tournaments, tournament.Name)
+				}
+			})
+			return
+		}
+	}
+}
+
+//RecordTournamentResult function stores the result of the room of a pairing and starts the next round once the current one is over
+func (lobby *Lobby) RecordTournamentResult(room *Room, winner Integer) {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	room.Pairing.Room = ""
+	room.Tournament.Record(room.Pairing, winner)
+	lobby.startPairings(room.Tournament, nil)
+}
+
+//sendBracket function sends the bracket to every connected player of the tournament. The lobby mutex must be held
+func (lobby *Lobby) sendBracket(tournament *Tournament) {
+	players := make(map[string]bool)
+	for _, player := range tournament.Players {
+		players[player] = true
+	}
+	bracket := &BracketMessage{Text: tournament.BracketString()}
+	for client := range lobby.Clients {
+		if client.Named && players[client.Name] {
+			client.Connection.Send(bracket)
+		}
+	}
+}
+
+//TournamentsString function draws every tournament of the lobby, for the host
+func (lobby *Lobby) TournamentsString() string {
+	lobby.Mutex.Lock()
+	defer lobby.Mutex.Unlock()
+	var brackets []string
+	for _, tournament := range lobby.Tournaments {
+		brackets = append(brackets, tournament.BracketString())
+	}
+	sort.Strings(brackets)
+	if len(brackets) == 0 {
+		return "There are no tournaments"
+	}
+	return strings.Join(brackets, "\n")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+//playTestTournament function starts a tournament of count players and plays it to the end, the winner of every pairing
+//is random and draws happen too. It fails the test if a round pairs a player twice or pairs an eliminated player
+func playTestTournament(t *testing.T, format Integer, count int, seed int64) *Tournament {
+	tournament := NewTournament("cup", format, "P1", seed)
+	for player := 1; player <= count; player++ {
+		if err := tournament.Join("P" + strconv.Itoa(player)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pairings, err := tournament.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := rand.New(rand.NewSource(seed))
+	for !tournament.Finished() {
+		if len(tournament.Rounds) > 4*count {
+			t.Fatalf("the tournament of %d players did not finish after %d rounds", count, len(tournament.Rounds))
+		}
+		alive := make(map[string]bool)
+		for _, player := range tournament.Alive() {
+			alive[player] = true
+		}
+		seen := make(map[string]bool)
+		for _, pairing := range pairings {
+			for _, player := range pairing.Players {
+				if player == BotName {
+					continue
+				}
+				if seen[player] {
+					t.Fatalf("round %d pairs %s twice", len(tournament.Rounds), player)
+				}
+				if !alive[player] {
+					t.Fatalf("round %d pairs %s, who is eliminated", len(tournament.Rounds), player)
+				}
+				seen[player] = true
+			}
+		}
+		if len(seen) != len(alive) {
+			t.Fatalf("round %d pairs %d of the %d players still alive", len(tournament.Rounds), len(seen), len(alive))
+		}
+		for _, pairing := range pairings {
+			if pairing.Winner < 0 {
+				tournament.Record(pairing, Integer(random.Intn(3)-1))
+			}
+		}
+		pairings = tournament.NextRound()
+	}
+	return tournament
+}
+
+//testByes function counts the byes of every player
+func testByes(tournament *Tournament) map[string]int {
+	byes := make(map[string]int)
+	for _, round := range tournament.Rounds {
+		for _, pairing := range round {
+			if pairing.Players[1] == BotName {
+				byes[pairing.Players[0]]++
+			}
+		}
+	}
+	return byes
+}
+
+//TestSingleElimination checks that every player but the champion loses exactly once and that byes are won
+func TestSingleElimination(t *testing.T) {
+	for _, test := range []struct {
+		players int
+		rounds  int
+		byes    int
+	}{
+		{2, 1, 0},
+		{3, 2, 1},
+		{4, 2, 0},
+		{5, 3, 2},
+		{7, 3, 1},
+		{8, 3, 0},
+		{9, 4, 3},
+	} {
+		for seed := int64(0); seed < 10; seed++ {
+			tournament := playTestTournament(t, SingleElimination, test.players, seed)
+			if len(tournament.Rounds) != test.rounds {
+				t.Fatalf("%d players played %d rounds instead of %d", test.players, len(tournament.Rounds), test.rounds)
+			}
+			byes := 0
+			for player, count := range testByes(tournament) {
+				byes += count
+				if tournament.Losses[player] > 1 {
+					t.Fatalf("%s lost after a bye", player)
+				}
+			}
+			if byes != test.byes {
+				t.Fatalf("%d players had %d byes instead of %d", test.players, byes, test.byes)
+			}
+			for _, player := range tournament.Players {
+				if losses := tournament.Losses[player]; (player == tournament.Champion) != (losses == 0) || losses > 1 {
+					t.Fatalf("%s lost %d times, the champion is %s", player, losses, tournament.Champion)
+				}
+			}
+		}
+	}
+}
+
+//TestDoubleElimination checks that players leave after their second loss, through the losers bracket,
+//and that the champion lost at most once
+func TestDoubleElimination(t *testing.T) {
+	for _, players := range []int{2, 3, 4, 5, 6, 7, 8, 9} {
+		for seed := int64(0); seed < 10; seed++ {
+			tournament := playTestTournament(t, DoubleElimination, players, seed)
+			for _, player := range tournament.Players {
+				losses := tournament.Losses[player]
+				if player == tournament.Champion && losses > 1 {
+					t.Fatalf("the champion %s lost %d times", player, losses)
+				}
+				if player != tournament.Champion && losses != 2 {
+					t.Fatalf("%s lost %d times but is not the champion", player, losses)
+				}
+			}
+			for round, pairings := range tournament.Rounds {
+				for _, pairing := range pairings {
+					if pairing.Bracket == "losers" && round == 0 {
+						t.Fatalf("%d players have a losers bracket in the first round", players)
+					}
+					if pairing.Bracket == "final" && round != len(tournament.Rounds)-1 && len(pairings) != 1 {
+						t.Fatalf("round %d has a final among other pairings", round+1)
+					}
+				}
+			}
+		}
+	}
+}
+
+//TestSwiss checks the number of rounds, that nobody gets two byes and that the champion leads the standings
+func TestSwiss(t *testing.T) {
+	for _, test := range []struct {
+		players int
+		rounds  int
+	}{
+		{2, 1},
+		{3, 2},
+		{4, 2},
+		{5, 3},
+		{7, 3},
+		{8, 3},
+		{9, 4},
+		{MaximumTournamentPlayers - 1, 6},
+	} {
+		for seed := int64(0); seed < 10; seed++ {
+			tournament := playTestTournament(t, SwissTournament, test.players, seed)
+			if Integer(len(tournament.Rounds)) != tournament.SwissRounds() || len(tournament.Rounds) != test.rounds {
+				t.Fatalf("%d players played %d rounds instead of %d", test.players, len(tournament.Rounds), test.rounds)
+			}
+			for player, byes := range testByes(tournament) {
+				if byes > 1 {
+					t.Fatalf("%s had %d byes", player, byes)
+				}
+			}
+			for _, player := range tournament.Players {
+				if tournament.Points[player] > tournament.Points[tournament.Champion] {
+					t.Fatalf("%s has more points than the champion %s", player, tournament.Champion)
+				}
+			}
+		}
+	}
+}
+
+//TestSwissAvoidsRematches checks that the pairing of a Swiss round prefers players that did not meet yet
+func TestSwissAvoidsRematches(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		tournament := playTestTournament(t, SwissTournament, 8, seed)
+		met := make(map[string]bool)
+		for _, round := range tournament.Rounds {
+			for _, pairing := range round {
+				key := pairing.Players[0] + "\n" + pairing.Players[1]
+				if met[key] {
+					t.Fatalf("%s and %s met twice", pairing.Players[0], pairing.Players[1])
+				}
+				met[key], met[pairing.Players[1]+"\n"+pairing.Players[0]] = true, true
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//WebSocketPath is where the lobby accepts WebSocket connections
+const WebSocketPath = "/ws"
+
+//WebSocketConn structure adapts a WebSocket to net.Conn, so the framed protocol travels unchanged
+//inside binary WebSocket messages
+type WebSocketConn struct {
+	*websocket.Conn
+	reader io.Reader
+}
+
+//NewWebSocketConn function wraps a WebSocket
+func NewWebSocketConn(conn *websocket.Conn) *WebSocketConn {
+	return &WebSocketConn{Conn: conn}
+}
+
+//Read function reads the binary messages one after the other as a single stream
+func (conn *WebSocketConn) Read(bytes []byte) (int, error) {
+	for {
+		if conn.reader == nil {
+			_, reader, err := conn.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			conn.reader = reader
+		}
+		n, err := conn.reader.Read(bytes)
+		if err == io.EOF {
+			conn.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+//Write function sends bytes as one binary message
+func (conn *WebSocketConn) Write(bytes []byte) (int, error) {
+	if err := conn.Conn.WriteMessage(websocket.BinaryMessage, bytes); err != nil {
+		return 0, err
+	}
+	return len(bytes), nil
+}
+
+//SetDeadline function
+func (conn *WebSocketConn) SetDeadline(deadline time.Time) error {
+	if err := conn.Conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	return conn.Conn.SetWriteDeadline(deadline)
+}
+
+//BufferedConn structure is a net.Conn whose first bytes were already peeked into Reader
+type BufferedConn struct {
+	net.Conn
+	Reader *bufio.Reader
+}
+
+//Read function
+func (conn *BufferedConn) Read(bytes []byte) (int, error) {
+	return conn.Reader.Read(bytes)
+}
+
+//ConnListener structure is a net.Listener fed with connections accepted somewhere else
+type ConnListener struct {
+	Conns     chan net.Conn
+	Address   net.Addr
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+//NewConnListener function creates a ConnListener that reports address as its own
+func NewConnListener(address net.Addr) *ConnListener {
+	return &ConnListener{Conns: make(chan net.Conn), Address: address, done: make(chan struct{})}
+}
+
+//Accept function
+func (listener *ConnListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.Conns:
+		return conn, nil
+	case <-listener.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+//Close function
+func (listener *ConnListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.done)
+	})
+	return nil
+}
+
+//Addr function
+func (listener *ConnListener) Addr() net.Addr {
+	return listener.Address
+}
+
+//Push function hands a connection to Accept, it closes conn if the listener is closed
+func (listener *ConnListener) Push(conn net.Conn) {
+	select {
+	case listener.Conns <- conn:
+	case <-listener.done:
+		conn.Close()
+	}
+}
+
+//WebSocketUpgrader accepts WebSockets from any origin so browsers served elsewhere can play
+var WebSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(request *http.Request) bool {
+		return true
+	},
+}
+
+//Transport interface opens the connections of the game, NetworkTransport uses the network and MemoryHub
+//keeps everything in memory so whole matches can run inside go test
+type Transport interface {
+	Listen(address string) (net.Listener, error)
+	Dial(address string) (net.Conn, error)
+}
+
+//NetworkTransport structure listens on TCP and dials with Dial, OnNewServer is called when a TLS certificate is pinned
+type NetworkTransport struct {
+	OnNewServer func(fingerprint string)
+}
+
+//Listen function
+func (transport *NetworkTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+//Dial function
+func (transport *NetworkTransport) Dial(address string) (net.Conn, error) {
+	return Dial(address, transport.OnNewServer)
+}
+
+//Dial function connects to a lobby server. Addresses starting with ws:// or wss:// use a WebSocket,
+//addresses starting with tls:// use TLS over TCP and addresses starting with tcp:// or without a scheme use raw TCP.
+//TLS certificates are pinned with ClientTLSConfig, onNewServer is called when a new one is trusted
+func Dial(address string, onNewServer func(fingerprint string)) (net.Conn, error) {
+	if strings.HasPrefix(address, "ws://") || strings.HasPrefix(address, "wss://") {
+		dialer := websocket.Dialer{HandshakeTimeout: ReconnectInterval}
+		if strings.HasPrefix(address, "wss://") {
+			parsed, err := url.Parse(address)
+			if err != nil {
+				return nil, err
+			}
+			dialer.TLSClientConfig = ClientTLSConfig(parsed.Host, onNewServer)
+		}
+		conn, _, err := dialer.Dial(address, nil)
+		if err != nil {
+			return nil, err
+		}
+		return NewWebSocketConn(conn), nil
+	}
+	if strings.HasPrefix(address, "tls://") {
+		host := strings.TrimPrefix(address, "tls://")
+		dialer := &net.Dialer{Timeout: ReconnectInterval}
+		return tls.DialWithDialer(dialer, "tcp", host, ClientTLSConfig(host, onNewServer))
+	}
+	return net.DialTimeout("tcp", strings.TrimPrefix(address, "tcp://"), ReconnectInterval)
+}
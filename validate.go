@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//Limites de los clientes del lobby: tamaño de sus mensajes, rafaga de mensajes, cada cuanto se recupera uno
+//y cuantos mensajes invalidos se toleran antes de desconectarlos
+const (
+	MaximumClientFrameSize = 4 * 1024
+	ClientMessageBurst     = 20
+	ClientMessageRefill    = 100 * time.Millisecond
+	MaximumStrikes         = 10
+	MaximumPasswordLength  = 64
+)
+
+//Limits structure bounds what a peer may send on a Connection, a Burst of 0 means no rate limit
+type Limits struct {
+	FrameSize Integer
+	Burst     Integer
+	Refill    time.Duration
+}
+
+//ClientLimits are the limits of the connections the lobby accepts
+var ClientLimits = Limits{FrameSize: MaximumClientFrameSize, Burst: ClientMessageBurst, Refill: ClientMessageRefill}
+
+//ClientMessages lists the message types a client may send to the lobby, any other one is only sent by servers
+var ClientMessages = map[string]bool{
+	"starting-hand": true,
+	"draft-pick":    true,
+	"deploy":        true,
+	"ready":         true,
+	"list-rooms":    true,
+	"create-room":   true,
+	"join-room":     true,
+	"leave-room":    true,
+	"resume":        true,
+	"set-name":      true,
+	"chat":          true,
+	"queue":         true,
+	"leave-queue":   true,
+	"confirm-match": true,
+	"tournament":    true,
+}
+
+//DisconnectedReason starts the error sent to an expelled client, which must not try to resume its match
+const DisconnectedReason = "disconnected by the server: "
+
+//Validator interface is implemented by the client messages with fields, Validate checks every one of them
+type Validator interface {
+	Validate() error
+}
+
+//Validate function
+func (message *StartingHandMessage) Validate() error {
+	return ValidateStartingHand(message.Cards, StartingCredit)
+}
+
+//Validate function
+func (message *DraftPickMessage) Validate() error {
+	if message.Index < 0 || message.Index >= DraftPackSize {
+		return fmt.Errorf("there is no card %d in the pack", message.Index+1)
+	}
+	return nil
+}
+
+//Validate function
+func (message *DeployMessage) Validate() error {
+	if message.HandIndex < 0 || message.HandIndex >= StartingHandSize {
+		return fmt.Errorf("there is no card %d in your hand", message.HandIndex+1)
+	}
+	if message.Row < 0 || message.Row >= BoardRows || message.Column < 0 || message.Column >= BoardColumns {
+		return fmt.Errorf("cell %d %d is outside the %dx%d board", message.Row+1, message.Column+1, BoardRows, BoardColumns)
+	}
+	return nil
+}
+
+//ReservedRoomPrefixes start the names of the rooms the lobby creates for ranked and tournament matches,
+//clients can not create rooms with them or they could take the name of one of those rooms
+var ReservedRoomPrefixes = []string{"ranked-", "tournament-"}
+
+//Validate function
+func (message *CreateRoomMessage) Validate() error {
+	for _, prefix := range ReservedRoomPrefixes {
+		if strings.HasPrefix(message.Name, prefix) {
+			return errors.New("room names starting with " + prefix + " are reserved for the rooms of the server")
+		}
+	}
+	return ValidateRoom(message.Name, message.Password)
+}
+
+//Validate function
+func (message *JoinRoomMessage) Validate() error {
+	return ValidateRoom(message.Name, message.Password)
+}
+
+//Validate function
+func (message *ResumeMessage) Validate() error {
+	if _, err := hex.DecodeString(message.Token); err != nil || len(message.Token) != 32 {
+		return errors.New("the session token is not valid")
+	}
+	return nil
+}
+
+//Validate function
+func (message *SetNameMessage) Validate() error {
+	if !utf8.ValidString(message.Name) {
+		return errors.New("the name is not valid UTF-8")
+	}
+	if _, err := hex.DecodeString(message.Secret); err != nil || (message.Secret != "" && len(message.Secret) != 32) {
+		return errors.New("the secret of the name is not valid")
+	}
+	return ValidateName(CleanChatText(message.Name))
+}
+
+//Validate function
+func (message *ChatMessage) Validate() error {
+	if !utf8.ValidString(message.Text) {
+		return errors.New("the message is not valid UTF-8")
+	}
+	if message.From != "" || message.Time != 0 {
+		return errors.New("only the server sets the sender and the time of a message")
+	}
+	return nil
+}
+
+//Validate function
+func (message *TournamentMessage) Validate() error {
+	switch message.Action {
+	case "list":
+		return nil
+	case "create":
+		if _, ok := TournamentFormats[message.Format]; !ok {
+			return errors.New("the format must be single, double or swiss")
+		}
+	case "join", "start", "show":
+	default:
+		return errors.New("unknown tournament action " + strconv.Quote(message.Action))
+	}
+	if err := ValidateRoom(message.Name, ""); err != nil {
+		return errors.New("tournament names follow the rules of room names: " + err.Error())
+	}
+	return nil
+}
+
+//ValidateRoom function checks the name and the password of a room
+func ValidateRoom(name, password string) error {
+	if !utf8.ValidString(name) || name != CleanChatText(name) {
+		return errors.New("the room name can not have control characters or surrounding spaces")
+	}
+	if err := ValidateName(name); err != nil {
+		return errors.New("room names follow the rules of player names: " + err.Error())
+	}
+	if len(password) > MaximumPasswordLength {
+		return errors.New("the password can not be longer than " + strconv.Itoa(MaximumPasswordLength) + " bytes")
+	}
+	return nil
+}
+
+//ValidateRequest function checks a message the player typed as the lobby will, it returns false after telling the player
+//what is wrong. The official client never sends an invalid message, so typos never count as strikes
+func (appManager *AppManager) ValidateRequest(message Message) bool {
+	validator, ok := message.(Validator)
+	if !ok {
+		return true
+	}
+	if err := validator.Validate(); err != nil {
+		appManager.WriteEntryAndUpdate(err.Error())
+		return false
+	}
+	return true
+}
+
+//Screen function checks a message before the lobby handles it. Invalid messages are answered with an error
+//and count as a strike, clients that reach MaximumStrikes or send messages only servers send are disconnected.
+//ValidateRequest rejects invalid messages before the official client sends them, so only modified clients get strikes.
+//It returns false if the message must be dropped
+func (lobby *Lobby) Screen(client *Client, message Message) bool {
+	if client.Kicked {
+		return false
+	}
+	if !ClientMessages[message.MessageType()] {
+		lobby.Kick(client, "sent "+message.MessageType()+", which only servers send")
+		return false
+	}
+	validator, ok := message.(Validator)
+	if !ok {
+		return true
+	}
+	err := validator.Validate()
+	if err == nil {
+		return true
+	}
+	client.Strikes++
+	if client.Strikes >= MaximumStrikes {
+		lobby.Kick(client, "sent "+strconv.Itoa(MaximumStrikes)+" invalid messages, the last one "+message.MessageType()+": "+err.Error())
+		return false
+	}
+	client.Connection.Send(&ErrorMessage{Reason: err.Error()})
+	return false
+}
+
+//Kick function expels an abusive client
+func (lobby *Lobby) Kick(client *Client, reason string) {
+	client.Kicked = true
+	lobby.Expel(client, reason)
+}
+
+//Expel function takes a client out of the queue and forfeits its match before disconnecting it.
+//Its session token is revoked first, so the client can not resume its seat
+func (lobby *Lobby) Expel(client *Client, reason string) {
+	lobby.Mutex.Lock()
+	if room := client.Room; room != nil && room.Clients[client.Seat] == client {
+		delete(lobby.Sessions, room.Tokens[client.Seat])
+	}
+	lobby.Mutex.Unlock()
+	lobby.LeaveQueue(client)
+	lobby.LeaveRoom(client, &LeaveRoomMessage{})
+	lobby.Disconnect(client, reason)
+}
+
+//Disconnect function closes the connection of a client, logging the reason and telling it to the client
+func (lobby *Lobby) Disconnect(client *Client, reason string) {
+	lobby.Log("Disconnecting client " + client.Connection.Conn.RemoteAddr().String() + ": " + reason)
+	client.Connection.Reject(DisconnectedReason + reason)
+}